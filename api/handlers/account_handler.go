@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccountHandler serves the deletion and restoration of accounts
+type AccountHandler struct {
+	DB          *gorm.DB
+	Cfg         *config.Config
+	logger      *log.Logger
+	tokenStore  *utils.TokenStore
+	revocations utils.RevocationStore
+	accounts    *utils.AccountStore
+	outbox      *utils.OutboxStore
+	audit       *utils.AuditStore
+	verifier    *secondFactorVerifier
+}
+
+func NewAccountHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *AccountHandler {
+	h := &AccountHandler{
+		DB:          db,
+		Cfg:         cfg,
+		logger:      log.New(log.Writer(), "AccountHandler: ", log.LstdFlags),
+		tokenStore:  utils.NewTokenStore(db),
+		revocations: revocations,
+		accounts:    utils.NewAccountStore(db, &cfg.Security.AccountDeletion),
+		outbox:      utils.NewOutboxStore(db),
+		audit:       utils.NewAuditStore(db),
+	}
+	h.verifier = newSecondFactorVerifier(db, cfg, h.logger, utils.NewMFAStore(db, &cfg.Security))
+	return h
+}
+
+// DeleteAccount deletes the account of the signed in user after checking the
+// password, and the second factor when one is enrolled. The user is signed
+// out everywhere and can restore the account until the grace period ends.
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.DeleteAccountRequest
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	if err := utils.ComparePasswords(user.Password, req.Password); err != nil {
+		h.logger.Printf("Failed password attempt for user %s while deleting account", user.UserID)
+		rb.Error(http.StatusForbidden, "Password is incorrect")
+		return
+	}
+	if h.Cfg.Features.EnableMFA && !h.verifier.verify(c, rb, &user, &req.SecondFactorAnswer) {
+		return
+	}
+
+	purgeAt := time.Now().Add(h.Cfg.Security.AccountDeletion.GracePeriod)
+	err := h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		ctx := c.Request.Context()
+		if err := h.accounts.WithTx(tx).DeleteAccount(ctx, user.UserID); err != nil {
+			return err
+		}
+		if err := h.tokenStore.WithTx(tx).RevokeAllSessions(ctx, user.UserID); err != nil {
+			return err
+		}
+
+		details := map[string]interface{}{"purge_at": purgeAt}
+		if err := h.audit.WithTx(tx).Record(ctx, models.AuditEvent{
+			UserID:    user.UserID,
+			Action:    utils.AuditAccountDeleted,
+			Details:   details,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}); err != nil {
+			return err
+		}
+
+		outbox := h.outbox.WithTx(tx)
+		if err := outbox.Enqueue(ctx, utils.OutboxTopicEmail, &email.Message{
+			To:       user.Email,
+			Template: "account_deleted",
+			Locale:   requestLocale(c),
+			Data: map[string]interface{}{
+				"Username":  user.Username,
+				"PurgeDate": purgeAt.UTC().Format("January 2, 2006 15:04 MST"),
+			},
+		}); err != nil {
+			return err
+		}
+		return outbox.EnqueueEvent(ctx, utils.EventAccountDeleted, user.UserID, details)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrLastOwner):
+			rb.Error(http.StatusConflict, "Transfer the ownership of your organizations before deleting your account")
+		case errors.Is(err, utils.ErrAccountNotFound):
+			rb.Error(http.StatusNotFound, "User not found")
+		default:
+			h.logger.Printf("Failed to delete account: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to delete account")
+		}
+		return
+	}
+
+	// Access and personal access tokens stop working as well
+	if err := h.revocations.RevokeUserTokens(c.Request.Context(), user.UserID, time.Now()); err != nil {
+		h.logger.Printf("Failed to revoke access tokens of user %s: %v", user.UserID, err)
+	}
+
+	h.logger.Printf("User %s deleted their account", user.UserID)
+	rb.Success(http.StatusOK, gin.H{"purge_at": purgeAt}, "Account deleted, sign in before it is purged to restore it")
+}
+
+// RestoreAccount undeletes an account within its grace period. The user signs
+// in again afterwards, through the second factor if one is enrolled.
+func (h *AccountHandler) RestoreAccount(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.RestoreAccountRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	user, err := h.accounts.FindDeleted(c.Request.Context(), strings.ToLower(req.Email))
+	if err != nil {
+		if errors.Is(err, utils.ErrAccountNotFound) {
+			rb.Error(http.StatusUnauthorized, "Invalid email or password")
+			return
+		}
+		h.logger.Printf("Failed to fetch deleted account: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to restore account")
+		return
+	}
+	if err := utils.ComparePasswords(user.Password, req.Password); err != nil {
+		h.logger.Printf("Failed password attempt for user %s while restoring account", user.UserID)
+		rb.Error(http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+
+	err = h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		ctx := c.Request.Context()
+		if err := h.accounts.WithTx(tx).RestoreAccount(ctx, user.UserID); err != nil {
+			return err
+		}
+		if err := h.audit.WithTx(tx).Record(ctx, models.AuditEvent{
+			UserID:    user.UserID,
+			Action:    utils.AuditAccountRestored,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}); err != nil {
+			return err
+		}
+		return h.outbox.WithTx(tx).EnqueueEvent(ctx, utils.EventAccountRestored, user.UserID, nil)
+	})
+	if err != nil {
+		// Purged since it was looked up
+		if errors.Is(err, utils.ErrAccountNotFound) {
+			rb.Error(http.StatusUnauthorized, "Invalid email or password")
+			return
+		}
+		h.logger.Printf("Failed to restore account: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to restore account")
+		return
+	}
+
+	h.logger.Printf("User %s restored their account", user.UserID)
+	rb.Success(http.StatusOK, nil, "Account restored, sign in to continue")
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"github.com/HersheyPlus/go-auth/config"
 	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
 	"github.com/HersheyPlus/go-auth/api/validators"
 	"github.com/HersheyPlus/go-auth/models"
 	"github.com/HersheyPlus/go-auth/utils"
@@ -20,15 +24,36 @@ type AuthHandler struct {
 	Cfg *config.Config
 	logger *log.Logger
 	tokenStore *utils.TokenStore
+	revocations utils.RevocationStore
+	rbac *utils.RBACStore
+	mfa *utils.MFAStore
+	webauthn *utils.WebAuthnStore
+	emailLogin *utils.EmailLoginStore
+	verifications *utils.EmailVerificationStore
+	accounts *utils.AccountStore
+	profiles *utils.ProfileStore
+	outbox *utils.OutboxStore
+	refresher *tokenRefresher
 }
 
-func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *AuthHandler {
+	h := &AuthHandler{
 		DB:  db,
 		Cfg: cfg,
 		logger: log.New(log.Writer(), "AuthHandler: ", log.LstdFlags),
 		tokenStore: utils.NewTokenStore(db),
+		revocations: revocations,
+		rbac: utils.NewRBACStore(db),
+		mfa: utils.NewMFAStore(db, &cfg.Security),
+		webauthn: utils.NewWebAuthnStore(db, &cfg.WebAuthn),
+		emailLogin: utils.NewEmailLoginStore(db, &cfg.Security),
+		verifications: utils.NewEmailVerificationStore(db, &cfg.Security.EmailVerification),
+		accounts: utils.NewAccountStore(db, &cfg.Security.AccountDeletion),
+		profiles: utils.NewProfileStore(db),
+		outbox: utils.NewOutboxStore(db),
 	}
+	h.refresher = &tokenRefresher{db: db, cfg: cfg, logger: h.logger, tokenStore: h.tokenStore, revocations: revocations, rbac: h.rbac, orgs: utils.NewOrganizationStore(db)}
+	return h
 }
 
 
@@ -45,6 +70,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		rb.Error(http.StatusBadRequest, err.Error())
 		return
 	}
+	// Addresses are stored in lower case, which every lookup relies on
+	req.Email = strings.ToLower(req.Email)
 
 	tx := h.DB.Begin()
 	defer func() {
@@ -54,17 +81,27 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		}
 	}()
 
-	var count int64
-	if err := tx.Model(&models.User{}).Where("email = ?", req.Email).Count(&count).Error; err != nil {
+	// Accounts awaiting deletion keep their address and name, they may be
+	// restored. The name stays locked until the user is created.
+	profiles := h.profiles.WithTx(tx)
+	if err := profiles.CheckEmailAvailable(c.Request.Context(), uuid.Nil, req.Email); err != nil {
 		tx.Rollback()
+		if errors.Is(err, utils.ErrEmailTaken) {
+			rb.Error(http.StatusConflict, "User with email already exists")
+			return
+		}
 		h.logger.Printf("Failed to check user existence: %v", err)
 		rb.Error(http.StatusInternalServerError, "Failed to check user existence")
 		return
 	}
-
-	if count > 0 {
+	if err := profiles.ClaimUsername(c.Request.Context(), uuid.Nil, req.Username); err != nil {
 		tx.Rollback()
-		rb.Error(http.StatusConflict, "User with email already exists")
+		if errors.Is(err, utils.ErrUsernameTaken) {
+			rb.Error(http.StatusConflict, "Username is already taken")
+			return
+		}
+		h.logger.Printf("Failed to check username: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to check user existence")
 		return
 	}
 
@@ -92,8 +129,77 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Give the new user the default role
+	rbac := h.rbac.WithTx(tx)
+	if err := rbac.AssignRoleByName(c.Request.Context(), newUser.UserID, utils.RoleUser); err != nil {
+		tx.Rollback()
+		h.logger.Printf("Failed to assign default role: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to register user")
+		return
+	}
+	roles, permissions, err := rbac.UserAccess(c.Request.Context(), newUser.UserID)
+	if err != nil {
+		tx.Rollback()
+		h.logger.Printf("Failed to resolve user access: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to register user")
+		return
+	}
+
+	// The verification link and the event are only sent if the user commits
+	outbox := h.outbox.WithTx(tx)
+	if h.Cfg.Features.EnableEmailVerification {
+		token, err := h.verifications.WithTx(tx).CreateToken(c.Request.Context(), newUser.UserID, newUser.Email)
+		if err == nil {
+			err = outbox.Enqueue(c.Request.Context(), utils.OutboxTopicEmail, verificationEmail(c, h.Cfg, &newUser, token))
+		}
+		if err != nil {
+			tx.Rollback()
+			h.logger.Printf("Failed to queue verification email: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to register user")
+			return
+		}
+	}
+	if err := outbox.EnqueueEvent(c.Request.Context(), utils.EventUserRegistered, newUser.UserID, map[string]string{"email": newUser.Email, "username": newUser.Username}); err != nil {
+		tx.Rollback()
+		h.logger.Printf("Failed to queue registration event: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to register user")
+		return
+	}
+
+	// Users the unverified email policy turns away are not signed in
+	scope, allowed := utils.UnverifiedLoginScope(h.Cfg, &newUser)
+	if !allowed {
+		if err := tx.Commit().Error; err != nil {
+			h.logger.Printf("Failed to commit transaction: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to complete registration")
+			return
+		}
+
+		dataResponse := struct {
+			User dto.UserRegisterResponse `json:"user"`
+		}{
+			User: dto.UserRegisterResponse{
+				Username:  newUser.Username,
+				FirstName: newUser.FirstName,
+				LastName:  newUser.LastName,
+				Phone:     newUser.Phone,
+				Email:     newUser.Email,
+			},
+		}
+		rb.Success(http.StatusCreated, dataResponse, "User registered, verify your email address to sign in")
+		return
+	}
+
 	// Generate tokens for automatic login
-    tokens, err := utils.GenerateTokenPair(newUser.UserID.String(), newUser.Username, &h.Cfg.JWT)
+    sessionID := uuid.New()
+    tokens, err := utils.GenerateTokenPair(utils.TokenOptions{
+        UserID:      newUser.UserID.String(),
+        Username:    newUser.Username,
+        SessionID:   sessionID.String(),
+        Scope:       scope,
+        Roles:       roles,
+        Permissions: permissions,
+    }, &h.Cfg.JWT)
     if err != nil {
         tx.Rollback()
         h.logger.Printf("Failed to generate tokens: %v", err)
@@ -102,7 +208,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
     }
 
     // Store refresh token
-    if err := h.tokenStore.StoreToken(c.Request.Context(), newUser.UserID, tokens); err != nil {
+    if err := h.tokenStore.WithTx(tx).CreateSession(c.Request.Context(), sessionID, newUser.UserID, sessionInfo(c, ""), tokens, h.Cfg.Security.MaxSessionsPerUser); err != nil {
         tx.Rollback()
         h.logger.Printf("Failed to store refresh token: %v", err)
         rb.Error(http.StatusInternalServerError, "Failed to complete registration process")
@@ -141,7 +247,6 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	rb.Success(http.StatusCreated, dataResponse, "User registered successfully")
 }
 
-
 func (h *AuthHandler) Login(c *gin.Context) {
     rb := dto.NewResponse(c)
     var req dto.UserLoginRequest
@@ -166,7 +271,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
     if err := tx.Where("email = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
         tx.Rollback()
         if err == gorm.ErrRecordNotFound {
-            rb.Error(http.StatusUnauthorized, "Invalid email or password")
+            h.deletedAccountLogin(c, rb, &req)
             return
         }
         h.logger.Printf("Database error during login: %v", err)
@@ -182,40 +287,485 @@ func (h *AuthHandler) Login(c *gin.Context) {
         return
     }
 
-    // Generate JWT token pair
-    tokens, err := utils.GenerateTokenPair(user.UserID.String(), user.Username, &h.Cfg.JWT)
+    // With MFA enabled the password only earns a challenge for the second factor
+    if h.Cfg.Features.EnableMFA {
+        methods, err := mfaMethods(c, h.Cfg, h.mfa, h.webauthn, user.UserID)
+        if err != nil {
+            tx.Rollback()
+            h.logger.Printf("Failed to check MFA enrollment: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to process login")
+            return
+        }
+        if len(methods) > 0 {
+            tx.Rollback()
+            h.mfaChallenge(rb, user.UserID, methods)
+            return
+        }
+    }
+
+    h.completeLogin(c, rb, tx, &user, req.DeviceName)
+}
+
+// deletedAccountLogin answers a login for an unknown address. The right
+// password of an account awaiting deletion gets the offer to restore it.
+func (h *AuthHandler) deletedAccountLogin(c *gin.Context, rb *dto.ResponseBuilder, req *dto.UserLoginRequest) {
+    if !h.Cfg.Features.EnableUserDeletion {
+        rb.Error(http.StatusUnauthorized, "Invalid email or password")
+        return
+    }
+
+    user, err := h.accounts.FindDeleted(c.Request.Context(), strings.ToLower(req.Email))
     if err != nil {
+        if errors.Is(err, utils.ErrAccountNotFound) {
+            rb.Error(http.StatusUnauthorized, "Invalid email or password")
+            return
+        }
+        h.logger.Printf("Database error during login: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+    if err := utils.ComparePasswords(user.Password, req.Password); err != nil {
+        h.logger.Printf("Failed password attempt for deleted user %s", user.UserID)
+        rb.Error(http.StatusUnauthorized, "Invalid email or password")
+        return
+    }
+
+    rb.ErrorWithCode(http.StatusForbidden, dto.CodeAccountDeleted, fmt.Sprintf("This account is scheduled for deletion on %s, restore it to sign in", h.accounts.PurgeAt(user).UTC().Format("January 2, 2006 15:04 MST")))
+}
+
+// LoginMFA completes a login awaiting a second factor by exchanging the MFA
+// challenge and a code from the user's authenticator for a token pair
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.MFALoginRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    userID, ok := h.mfaChallengeUser(rb, req.MFAToken)
+    if !ok {
+        return
+    }
+
+    usedRecovery, err := h.mfa.Verify(c.Request.Context(), userID, req.Code, req.RecoveryCode)
+    if err != nil {
+        switch {
+        case errors.Is(err, utils.ErrMFAInvalidCode), errors.Is(err, utils.ErrMFANotEnrolled):
+            h.logger.Printf("Failed MFA attempt for user %s", userID)
+            rb.Error(http.StatusUnauthorized, "Invalid authentication code")
+        case errors.Is(err, utils.ErrMFALocked):
+            rb.Error(http.StatusTooManyRequests, "Too many failed attempts, try again later")
+        default:
+            h.logger.Printf("Failed to verify MFA code: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to process login")
+        }
+        return
+    }
+
+    tx := h.DB.Begin()
+    defer func() {
+        if r := recover(); r != nil {
+            tx.Rollback()
+            h.logger.Printf("Recovered from panic in LoginMFA: %v", r)
+        }
+    }()
+
+    var user models.User
+    if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
         tx.Rollback()
-        h.logger.Printf("Failed to generate tokens: %v", err)
-        rb.Error(http.StatusInternalServerError, "Failed to generate authentication tokens")
+        if err == gorm.ErrRecordNotFound {
+            rb.Error(http.StatusUnauthorized, "Invalid or expired MFA token")
+            return
+        }
+        h.logger.Printf("Database error during MFA login: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
         return
     }
+    if usedRecovery {
+        notifyRecoveryCodeUsed(c, h.outbox, h.mfa, h.logger, &user)
+    }
 
-    // Store refresh token
-    refreshToken := models.RefreshToken{
-        UserID:    user.UserID,
-        TokenUUID: tokens.RefreshUuid,
-        ExpiresAt: tokens.RtExpires,
+    h.completeLogin(c, rb, tx, &user, req.DeviceName)
+}
+
+// RequestEmailLogin emails a one-time login code, and a magic link when a link
+// page is configured. The response is the same whether or not the address has
+// an account.
+func (h *AuthHandler) RequestEmailLogin(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.EmailLoginRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    // Hash first so unknown addresses do the same work as known ones
+    code, codeHash, err := h.emailLogin.NewEmailLoginCode()
+    if err != nil {
+        h.logger.Printf("Failed to generate login code: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    var user models.User
+    err = h.DB.WithContext(c.Request.Context()).Where("email = ?", strings.ToLower(req.Email)).First(&user).Error
+    switch {
+    case err == nil:
+        h.sendEmailLogin(c, &user, code, codeHash)
+    case err != gorm.ErrRecordNotFound:
+        h.logger.Printf("Database error during email login: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    rb.Success(http.StatusOK, nil, "If an account exists for this email, a login code has been sent")
+}
+
+// sendEmailLogin issues a challenge and queues its email. Failures are only
+// logged so the response cannot tell them apart from an unknown address, and
+// the email is sent in the background so its delivery time cannot either.
+func (h *AuthHandler) sendEmailLogin(c *gin.Context, user *models.User, code string, codeHash string) {
+    ctx := c.Request.Context()
+    err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        challenge, err := h.emailLogin.WithTx(tx).Issue(ctx, user.UserID, codeHash)
+        if err != nil {
+            return err
+        }
+
+        var link string
+        if linkURL := h.Cfg.Security.EmailLogin.LinkURL; linkURL != "" {
+            token, err := utils.GenerateEmailLoginToken(user.UserID.String(), challenge.ID.String(), challenge.ExpiresAt, &h.Cfg.JWT)
+            if err != nil {
+                return fmt.Errorf("failed to sign login link: %w", err)
+            }
+            link = fmt.Sprintf("%s?token=%s", linkURL, url.QueryEscape(token))
+        }
+
+        return h.outbox.WithTx(tx).Enqueue(ctx, utils.OutboxTopicEmail, &email.Message{
+            To:       user.Email,
+            Template: "email_login",
+            Locale:   requestLocale(c),
+            Data: map[string]interface{}{
+                "Username": user.Username,
+                "Code":     code,
+                "Link":     link,
+                "Minutes":  int(h.Cfg.Security.EmailLogin.CodeTTL.Minutes()),
+            },
+        })
+    })
+    if err != nil {
+        if errors.Is(err, utils.ErrEmailLoginThrottled) {
+            h.logger.Printf("Skipped login code for user %s, one was sent recently", user.UserID)
+            return
+        }
+        h.logger.Printf("Failed to issue login code for user %s: %v", user.UserID, err)
+    }
+}
+
+// VerifyEmailLogin redeems an emailed login code or magic link. Users with a
+// second factor still have to answer the MFA challenge.
+func (h *AuthHandler) VerifyEmailLogin(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.EmailLoginVerifyRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    var userID uuid.UUID
+    var err error
+    if req.Token != "" {
+        claims, validateErr := utils.ValidateEmailLoginToken(req.Token, &h.Cfg.JWT)
+        if validateErr != nil {
+            rb.Error(http.StatusUnauthorized, "Invalid or expired login code")
+            return
+        }
+        var challengeID uuid.UUID
+        userID, err = uuid.Parse(claims.UserID)
+        if err == nil {
+            challengeID, err = uuid.Parse(claims.ID)
+        }
+        if err != nil {
+            rb.Error(http.StatusUnauthorized, "Invalid or expired login code")
+            return
+        }
+        err = h.emailLogin.RedeemLink(c.Request.Context(), userID, challengeID)
+    } else {
+        // Unknown addresses are checked against no challenge and fail like a
+        // wrong code
+        var user models.User
+        lookupErr := h.DB.WithContext(c.Request.Context()).Where("email = ?", strings.ToLower(req.Email)).First(&user).Error
+        if lookupErr != nil && lookupErr != gorm.ErrRecordNotFound {
+            h.logger.Printf("Database error during email login: %v", lookupErr)
+            rb.Error(http.StatusInternalServerError, "Failed to process login")
+            return
+        }
+        userID = user.UserID
+        err = h.emailLogin.RedeemCode(c.Request.Context(), userID, req.Code)
+    }
+    if err != nil {
+        if errors.Is(err, utils.ErrEmailLoginInvalid) {
+            h.logger.Printf("Failed email login attempt")
+            rb.Error(http.StatusUnauthorized, "Invalid or expired login code")
+            return
+        }
+        h.logger.Printf("Failed to verify login code: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    // The code arrived by email, which proves the address as well
+    if err := h.verifications.MarkVerified(c.Request.Context(), userID); err != nil {
+        h.logger.Printf("Failed to mark email of user %s verified: %v", userID, err)
+    }
+
+    if h.Cfg.Features.EnableMFA {
+        methods, err := mfaMethods(c, h.Cfg, h.mfa, h.webauthn, userID)
+        if err != nil {
+            h.logger.Printf("Failed to check MFA enrollment: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to process login")
+            return
+        }
+        if len(methods) > 0 {
+            h.mfaChallenge(rb, userID, methods)
+            return
+        }
+    }
+
+    h.loginUser(c, rb, userID, req.DeviceName)
+}
+
+// mfaChallenge answers the password step of a login with a challenge token
+func (h *AuthHandler) mfaChallenge(rb *dto.ResponseBuilder, userID uuid.UUID, methods []string) {
+    token, err := utils.GenerateMFAChallenge(userID.String(), h.Cfg.Security.MFA.ChallengeTTL, &h.Cfg.JWT)
+    if err != nil {
+        h.logger.Printf("Failed to generate MFA challenge: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    rb.Success(http.StatusOK, dto.MFAChallengeResponse{
+        MFARequired: true,
+        MFAToken:    token,
+        Methods:     methods,
+        ExpiresIn:   int64(h.Cfg.Security.MFA.ChallengeTTL.Seconds()),
+    }, "Multi-factor authentication required")
+}
+
+// WebAuthnLoginBegin starts a passwordless login. No credentials are listed,
+// the authenticator offers its discoverable ones, so the endpoint reveals
+// nothing about which accounts exist.
+func (h *AuthHandler) WebAuthnLoginBegin(c *gin.Context) {
+    rb := dto.NewResponse(c)
+
+    session, err := h.webauthn.BeginCeremony(c.Request.Context(), utils.CeremonyLogin, nil)
+    if err != nil {
+        h.logger.Printf("Failed to start passkey login: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to start login")
+        return
+    }
+
+    rb.Success(http.StatusOK, requestOptions(h.Cfg, session, nil, "required"), "Login started")
+}
+
+// WebAuthnLoginFinish logs in with a passkey alone. The authenticator must have
+// verified the user, so the passkey already stands for two factors.
+func (h *AuthHandler) WebAuthnLoginFinish(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.WebAuthnLoginRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    credential, ok := h.finishAssertion(c, rb, req.SessionID, utils.CeremonyLogin, &req.Credential)
+    if !ok {
+        return
+    }
+
+    h.loginUser(c, rb, credential.UserID, req.DeviceName)
+}
+
+// LoginMFAWebAuthnBegin starts a passkey assertion answering the MFA
+// challenge of a password login
+func (h *AuthHandler) LoginMFAWebAuthnBegin(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.WebAuthnMFAOptionsRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    userID, ok := h.mfaChallengeUser(rb, req.MFAToken)
+    if !ok {
+        return
+    }
+
+    credentials, err := h.webauthn.ListCredentials(c.Request.Context(), userID)
+    if err != nil {
+        h.logger.Printf("Failed to list credentials: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+    if len(credentials) == 0 {
+        rb.Error(http.StatusBadRequest, "No passkey is registered")
+        return
+    }
+
+    session, err := h.webauthn.BeginCeremony(c.Request.Context(), utils.CeremonySecondFactor, &userID)
+    if err != nil {
+        h.logger.Printf("Failed to start passkey assertion: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    rb.Success(http.StatusOK, requestOptions(h.Cfg, session, credentials, "preferred"), "Passkey assertion started")
+}
+
+// LoginMFAWebAuthnFinish completes a login awaiting a second factor with a
+// passkey assertion
+func (h *AuthHandler) LoginMFAWebAuthnFinish(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.WebAuthnMFALoginRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    userID, ok := h.mfaChallengeUser(rb, req.MFAToken)
+    if !ok {
+        return
+    }
+
+    // The session is bound to the challenged user, so another user's passkey
+    // cannot answer it
+    credential, ok := h.finishAssertion(c, rb, req.SessionID, utils.CeremonySecondFactor, &req.Credential)
+    if !ok {
+        return
+    }
+    if credential.UserID != userID {
+        rb.Error(http.StatusUnauthorized, "Passkey could not be verified")
+        return
+    }
+
+    h.loginUser(c, rb, userID, req.DeviceName)
+}
+
+// mfaChallengeUser returns the user an MFA challenge token was issued to
+func (h *AuthHandler) mfaChallengeUser(rb *dto.ResponseBuilder, token string) (uuid.UUID, bool) {
+    claims, err := utils.ValidateMFAChallenge(token, &h.Cfg.JWT)
+    if err != nil {
+        rb.Error(http.StatusUnauthorized, "Invalid or expired MFA token")
+        return uuid.Nil, false
     }
+    userID, err := uuid.Parse(claims.UserID)
+    if err != nil {
+        rb.Error(http.StatusUnauthorized, "Invalid or expired MFA token")
+        return uuid.Nil, false
+    }
+    return userID, true
+}
+
+// finishAssertion verifies a passkey assertion and writes the error response
+// itself
+func (h *AuthHandler) finishAssertion(c *gin.Context, rb *dto.ResponseBuilder, sessionID uuid.UUID, ceremony string, credential *dto.AssertionCredential) (*models.WebAuthnCredential, bool) {
+    verified, err := h.webauthn.FinishAssertion(c.Request.Context(), sessionID, ceremony, assertionResponse(credential))
+    if err != nil {
+        switch {
+        case errors.Is(err, utils.ErrWebAuthnSessionInvalid):
+            rb.Error(http.StatusBadRequest, "Login is invalid or expired, start a new one")
+        case errors.Is(err, utils.ErrWebAuthnVerification), errors.Is(err, utils.ErrCredentialNotFound):
+            h.logger.Printf("Failed passkey assertion: %v", err)
+            rb.Error(http.StatusUnauthorized, "Passkey could not be verified")
+        default:
+            h.logger.Printf("Failed to verify passkey: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to process login")
+        }
+        return nil, false
+    }
+    return verified, true
+}
 
-    // Delete existing refresh tokens for user (optional, based on your requirements)
-    if err := tx.Where("user_id = ?", user.UserID).Delete(&models.RefreshToken{}).Error; err != nil {
+// loginUser logs in a user authenticated without the password, by a passkey or
+// an emailed code
+func (h *AuthHandler) loginUser(c *gin.Context, rb *dto.ResponseBuilder, userID uuid.UUID, deviceName string) {
+    tx := h.DB.Begin()
+    defer func() {
+        if r := recover(); r != nil {
+            tx.Rollback()
+            h.logger.Printf("Recovered from panic in passwordless login: %v", r)
+        }
+    }()
+
+    var user models.User
+    if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
         tx.Rollback()
-        h.logger.Printf("Failed to delete old refresh tokens: %v", err)
+        if err == gorm.ErrRecordNotFound {
+            rb.Error(http.StatusUnauthorized, "User not found")
+            return
+        }
+        h.logger.Printf("Database error during passwordless login: %v", err)
         rb.Error(http.StatusInternalServerError, "Failed to process login")
         return
     }
 
-    // Save new refresh token
-    if err := tx.Create(&refreshToken).Error; err != nil {
+    h.completeLogin(c, rb, tx, &user, deviceName)
+}
+
+// completeLogin issues the token pair for an authenticated user, stores the
+// session and commits the transaction
+func (h *AuthHandler) completeLogin(c *gin.Context, rb *dto.ResponseBuilder, tx *gorm.DB, user *models.User, deviceName string) {
+    // Users who have not verified their email may be limited or turned away
+    scope, allowed := utils.UnverifiedLoginScope(h.Cfg, user)
+    if !allowed {
         tx.Rollback()
-        h.logger.Printf("Failed to store refresh token: %v", err)
+        rb.ErrorWithCode(http.StatusForbidden, dto.CodeEmailNotVerified, "Verify your email address before signing in")
+        return
+    }
+
+    // Resolve the roles and permissions embedded in the access token
+    roles, permissions, err := h.rbac.WithTx(tx).UserAccess(c.Request.Context(), user.UserID)
+    if err != nil {
+        tx.Rollback()
+        h.logger.Printf("Failed to resolve user access: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to process login")
+        return
+    }
+
+    // Generate JWT token pair for a new session
+    sessionID := uuid.New()
+    tokens, err := utils.GenerateTokenPair(utils.TokenOptions{
+        UserID:      user.UserID.String(),
+        Username:    user.Username,
+        SessionID:   sessionID.String(),
+        Scope:       scope,
+        Roles:       roles,
+        Permissions: permissions,
+    }, &h.Cfg.JWT)
+    if err != nil {
+        tx.Rollback()
+        h.logger.Printf("Failed to generate tokens: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to generate authentication tokens")
+        return
+    }
+
+    // Store the session and its refresh token, evicting the oldest sessions above the cap
+    if err := h.tokenStore.WithTx(tx).CreateSession(c.Request.Context(), sessionID, user.UserID, sessionInfo(c, deviceName), tokens, h.Cfg.Security.MaxSessionsPerUser); err != nil {
+        tx.Rollback()
+        h.logger.Printf("Failed to store session: %v", err)
         rb.Error(http.StatusInternalServerError, "Failed to complete login process")
         return
     }
 
     // Update last login time
-    if err := tx.Model(&user).Update("last_login", time.Now()).Error; err != nil {
+    if err := tx.Model(user).Update("last_login", time.Now()).Error; err != nil {
         tx.Rollback()
         h.logger.Printf("Failed to update last login: %v", err)
         rb.Error(http.StatusInternalServerError, "Failed to update login information")
@@ -244,6 +794,36 @@ func (h *AuthHandler) Login(c *gin.Context) {
     rb.Success(http.StatusOK, response, "Login successful")
 }
 
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+    rb := dto.NewResponse(c)
+    var req dto.RefreshTokenRequest
+
+    if err := c.ShouldBindJSON(&req); err != nil {
+        rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+        return
+    }
+
+    tokens, _, err := h.refresher.refresh(c, req.RefreshToken, "", nil)
+    if err != nil {
+        if errors.Is(err, errRefreshTokenRejected) {
+            rb.Error(http.StatusUnauthorized, "Invalid or expired refresh token")
+            return
+        }
+        h.logger.Printf("Failed to refresh token: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to refresh token")
+        return
+    }
+
+    response := dto.TokenRefreshResponse{
+        AccessToken:  tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+        TokenType:    "Bearer",
+        ExpiresIn:    int64(h.Cfg.JWT.AccessTokenExpiry.Seconds()),
+    }
+
+    rb.Success(http.StatusOK, response, "Token refreshed successfully")
+}
+
 func (h *AuthHandler) Logout(c *gin.Context) {
     rb := dto.NewResponse(c)
 
@@ -262,7 +842,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
     }
 
     // Validate access token
-    claims, err := utils.ValidateToken(tokenParts[1], h.Cfg.JWT.SecretKey)
+    claims, err := utils.ValidateAccessToken(tokenParts[1], &h.Cfg.JWT)
     if err != nil {
         rb.Error(http.StatusUnauthorized, "Invalid or expired token")
         return
@@ -285,10 +865,20 @@ func (h *AuthHandler) Logout(c *gin.Context) {
         }
     }()
 
-    // Delete refresh tokens for the user
-    if err := tx.Where("user_id = ?", userID).Delete(&models.RefreshToken{}).Error; err != nil {
+    // End the current session, or every session for tokens issued without one
+    store := h.tokenStore.WithTx(tx)
+    sessionID, sessionErr := uuid.Parse(claims.SessionID)
+    if sessionErr == nil {
+        err = store.RevokeSession(c.Request.Context(), userID, sessionID)
+        if errors.Is(err, utils.ErrSessionNotFound) {
+            err = nil
+        }
+    } else {
+        err = store.RevokeAllSessions(c.Request.Context(), userID)
+    }
+    if err != nil {
         tx.Rollback()
-        h.logger.Printf("Failed to delete refresh tokens: %v", err)
+        h.logger.Printf("Failed to revoke session: %v", err)
         rb.Error(http.StatusInternalServerError, "Failed to process logout")
         return
     }
@@ -313,6 +903,19 @@ func (h *AuthHandler) Logout(c *gin.Context) {
         return
     }
 
+    // Every access token of the ended sessions stops working, including the
+    // ones refreshed earlier in the session
+    if sessionErr == nil {
+        err = revokeSessionTokens(c, h.Cfg, h.revocations, sessionID)
+    } else {
+        err = h.revocations.RevokeUserTokens(c.Request.Context(), userID, time.Now())
+    }
+    if err != nil {
+        h.logger.Printf("Failed to revoke access tokens: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to complete logout")
+        return
+    }
+
     rb.Success(http.StatusOK, nil, "Logged out successfully")
 }
 
@@ -338,16 +941,25 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
     }
 
 
-    response := dto.UserProfileResponse{
-        UserID:    user.UserID,
-        Username:  user.Username,
-        FirstName: user.FirstName,
-        LastName:  user.LastName,
-        Phone:     user.Phone,
-        Email:     user.Email,
-        CreatedAt: user.CreatedAt,
-        UpdatedAt: user.UpdatedAt,
+    rb.Success(http.StatusOK, profileResponse(&user), "Profile retrieved successfully")
+}
+
+// requestLocale picks the preferred language of the request for emails
+func requestLocale(c *gin.Context) string {
+    preferred, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+    locale, _, _ := strings.Cut(preferred, ";")
+    locale = strings.TrimSpace(locale)
+    if locale == "*" {
+        return ""
     }
+    return locale
+}
 
-    rb.Success(http.StatusOK, response, "Profile retrieved successfully")
-}
\ No newline at end of file
+// sessionInfo collects the device details of the current request
+func sessionInfo(c *gin.Context, deviceName string) utils.SessionInfo {
+    return utils.SessionInfo{
+        DeviceName: deviceName,
+        UserAgent:  c.Request.UserAgent(),
+        IPAddress:  c.ClientIP(),
+    }
+}
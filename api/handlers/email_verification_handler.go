@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EmailVerificationHandler serves email address verification
+type EmailVerificationHandler struct {
+	DB            *gorm.DB
+	Cfg           *config.Config
+	logger        *log.Logger
+	verifications *utils.EmailVerificationStore
+	outbox        *utils.OutboxStore
+	audit         *utils.AuditStore
+}
+
+func NewEmailVerificationHandler(db *gorm.DB, cfg *config.Config) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		DB:            db,
+		Cfg:           cfg,
+		logger:        log.New(log.Writer(), "EmailVerificationHandler: ", log.LstdFlags),
+		verifications: utils.NewEmailVerificationStore(db, &cfg.Security.EmailVerification),
+		outbox:        utils.NewOutboxStore(db),
+		audit:         utils.NewAuditStore(db),
+	}
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified,
+// switching the account to it when the token confirms a change of address
+func (h *EmailVerificationHandler) VerifyEmail(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.VerifyEmailRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var verified *utils.VerifiedEmail
+	err := h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if verified, err = h.verifications.WithTx(tx).VerifyEmail(c.Request.Context(), req.Token); err != nil {
+			return err
+		}
+		if verified.PreviousEmail == "" {
+			return h.outbox.WithTx(tx).EnqueueEvent(c.Request.Context(), utils.EventEmailVerified, verified.UserID, nil)
+		}
+
+		details := map[string]interface{}{"previous_email": verified.PreviousEmail, "email": verified.Email}
+		if err := h.audit.WithTx(tx).Record(c.Request.Context(), models.AuditEvent{
+			UserID:    verified.UserID,
+			Action:    utils.AuditEmailChanged,
+			Details:   details,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}); err != nil {
+			return err
+		}
+		return h.outbox.WithTx(tx).EnqueueEvent(c.Request.Context(), utils.EventEmailChanged, verified.UserID, details)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrEmailVerificationInvalid):
+			rb.Error(http.StatusBadRequest, "Invalid or expired verification token")
+		case errors.Is(err, utils.ErrEmailTaken):
+			rb.Error(http.StatusConflict, "Email address is already in use by another account")
+		default:
+			h.logger.Printf("Failed to verify email: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to verify email")
+		}
+		return
+	}
+
+	if verified.PreviousEmail != "" {
+		h.logger.Printf("User %s changed their email", verified.UserID)
+		rb.Success(http.StatusOK, nil, "Email address changed successfully")
+		return
+	}
+	h.logger.Printf("User %s verified their email", verified.UserID)
+	rb.Success(http.StatusOK, nil, "Email verified successfully")
+}
+
+// ResendVerification emails a new verification link. The response is the same
+// whether or not the address has an unverified account.
+func (h *EmailVerificationHandler) ResendVerification(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.ResendVerificationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var user models.User
+	err := h.DB.WithContext(c.Request.Context()).Where("email = ?", strings.ToLower(req.Email)).First(&user).Error
+	switch {
+	case err == nil:
+		if user.EmailVerifiedAt == nil {
+			h.resendVerification(c, &user)
+		}
+	case err != gorm.ErrRecordNotFound:
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "If an unverified account exists for this email, a verification link has been sent")
+}
+
+// resendVerification issues a verification token and queues the email.
+// Failures are only logged so the response cannot tell them apart from an
+// unknown address.
+func (h *EmailVerificationHandler) resendVerification(c *gin.Context, user *models.User) {
+	err := h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		token, err := h.verifications.WithTx(tx).CreateToken(c.Request.Context(), user.UserID, user.Email)
+		if err != nil {
+			return err
+		}
+		return h.outbox.WithTx(tx).Enqueue(c.Request.Context(), utils.OutboxTopicEmail, verificationEmail(c, h.Cfg, user, token))
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrEmailVerificationThrottled) {
+			h.logger.Printf("Skipped verification email for user %s, one was sent recently", user.UserID)
+			return
+		}
+		h.logger.Printf("Failed to queue verification email: %v", err)
+	}
+}
+
+// verificationEmail builds the message that carries a verification token
+func verificationEmail(c *gin.Context, cfg *config.Config, user *models.User, token string) *email.Message {
+	return &email.Message{
+		To:       user.Email,
+		Template: "verify_email",
+		Locale:   requestLocale(c),
+		Data: map[string]interface{}{
+			"Username": user.Username,
+			"Token":    token,
+			"Link":     verificationLink(cfg, token),
+			"Hours":    int(cfg.Security.EmailVerification.TokenTTL.Hours()),
+		},
+	}
+}
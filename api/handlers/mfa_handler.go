@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// qrCodeScale is the size in pixels of one QR code module
+const qrCodeScale = 6
+
+// Second factors a login challenge can be answered with
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodWebAuthn = "webauthn"
+)
+
+// MFAHandler serves TOTP authenticator enrollment and recovery codes for the
+// signed in user
+type MFAHandler struct {
+	DB       *gorm.DB
+	Cfg      *config.Config
+	logger   *log.Logger
+	mfa      *utils.MFAStore
+	verifier *secondFactorVerifier
+}
+
+func NewMFAHandler(db *gorm.DB, cfg *config.Config) *MFAHandler {
+	h := &MFAHandler{
+		DB:     db,
+		Cfg:    cfg,
+		logger: log.New(log.Writer(), "MFAHandler: ", log.LstdFlags),
+		mfa:    utils.NewMFAStore(db, &cfg.Security),
+	}
+	h.verifier = newSecondFactorVerifier(db, cfg, h.logger, h.mfa)
+	return h
+}
+
+// EnrollTOTP starts an enrollment. The authenticator only guards logins once
+// ConfirmTOTP has seen a valid code from it.
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	secret, err := h.mfa.BeginEnrollment(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrMFAAlreadyEnrolled) {
+			rb.Error(http.StatusConflict, "An authenticator is already enrolled")
+			return
+		}
+		h.logger.Printf("Failed to start enrollment: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	issuer := h.Cfg.Security.MFA.Issuer
+	if issuer == "" {
+		issuer = h.Cfg.App.Name
+	}
+	uri := utils.TOTPURI(issuer, user.Email, secret)
+	qrCode, err := utils.QRCodePNG(uri, qrCodeScale)
+	if err != nil {
+		h.logger.Printf("Failed to render QR code: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	rb.Success(http.StatusOK, dto.TOTPEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode),
+	}, "Scan the QR code with your authenticator app and confirm with a code")
+}
+
+// ConfirmTOTP activates the pending enrollment and returns the first set of
+// recovery codes
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	if err := h.mfa.ConfirmEnrollment(c.Request.Context(), userID, req.Code); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrMFANotEnrolled):
+			rb.Error(http.StatusNotFound, "No pending enrollment, start one first")
+		case errors.Is(err, utils.ErrMFAAlreadyEnrolled):
+			rb.Error(http.StatusConflict, "An authenticator is already enrolled")
+		case errors.Is(err, utils.ErrMFAInvalidCode):
+			rb.Error(http.StatusBadRequest, "Invalid authentication code")
+		default:
+			h.logger.Printf("Failed to confirm enrollment: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to confirm enrollment")
+		}
+		return
+	}
+	h.logger.Printf("User %s enabled TOTP authentication", userID)
+
+	codes, err := h.mfa.GenerateRecoveryCodes(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to generate recovery codes: %v", err)
+		rb.Error(http.StatusInternalServerError, "Authenticator enrolled but recovery codes could not be generated, regenerate them")
+		return
+	}
+
+	rb.Success(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes}, "Authenticator enrolled successfully, store the recovery codes now as they cannot be shown again")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, invalidating the old set
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	if !h.verifySecondFactor(c, rb, userID, &req) {
+		return
+	}
+
+	codes, err := h.mfa.GenerateRecoveryCodes(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to generate recovery codes: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	h.logger.Printf("User %s regenerated recovery codes", userID)
+	rb.Success(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes}, "Recovery codes regenerated, store them now as they cannot be shown again")
+}
+
+// DisableTOTP removes the authenticator. A second factor, a current code or a
+// passkey, is required so that a stolen session alone cannot turn it off.
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	// Checked first so that no recovery code is spent for nothing
+	enabled, err := h.mfa.Enabled(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to check MFA enrollment: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to disable authenticator")
+		return
+	}
+	if !enabled {
+		rb.Error(http.StatusNotFound, "No authenticator is enrolled")
+		return
+	}
+	if !h.verifySecondFactor(c, rb, userID, &req) {
+		return
+	}
+
+	if err := h.mfa.Disable(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, utils.ErrMFANotEnrolled) {
+			rb.Error(http.StatusNotFound, "No authenticator is enrolled")
+			return
+		}
+		h.logger.Printf("Failed to disable authenticator: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to disable authenticator")
+		return
+	}
+
+	h.logger.Printf("User %s disabled TOTP authentication", userID)
+	rb.Success(http.StatusOK, nil, "Authenticator removed successfully")
+}
+
+// verifySecondFactor checks the answer of the request against the user's
+// second factors and writes the error response itself. Users without any are
+// refused as there is nothing to manage.
+func (h *MFAHandler) verifySecondFactor(c *gin.Context, rb *dto.ResponseBuilder, userID uuid.UUID, req *dto.MFACodeRequest) bool {
+	methods, err := mfaMethods(c, h.Cfg, h.mfa, h.verifier.webauthn, userID)
+	if err != nil {
+		h.logger.Printf("Failed to check MFA enrollment: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to verify the second factor")
+		return false
+	}
+	if len(methods) == 0 {
+		rb.Error(http.StatusNotFound, "No authenticator is enrolled")
+		return false
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return false
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to verify the second factor")
+		return false
+	}
+	return h.verifier.verify(c, rb, &user, &req.SecondFactorAnswer)
+}
+
+// mfaMethods lists the second factors the user has set up
+func mfaMethods(c *gin.Context, cfg *config.Config, mfa *utils.MFAStore, webauthn *utils.WebAuthnStore, userID uuid.UUID) ([]string, error) {
+	var methods []string
+	enabled, err := mfa.Enabled(c.Request.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	if cfg.Features.EnablePasskeys {
+		registered, err := webauthn.HasCredentials(c.Request.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+		if registered {
+			methods = append(methods, mfaMethodWebAuthn)
+		}
+	}
+	return methods, nil
+}
+
+// hasMFAMethod reports whether the method is among the user's second factors
+func hasMFAMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyRecoveryCodeUsed tells the user a recovery code was spent so that an
+// unexpected use does not go unnoticed. The code is already spent, so failures
+// to queue the notice are only logged.
+func notifyRecoveryCodeUsed(c *gin.Context, outbox *utils.OutboxStore, mfa *utils.MFAStore, logger *log.Logger, user *models.User) {
+	remaining, err := mfa.RemainingRecoveryCodes(c.Request.Context(), user.UserID)
+	if err != nil {
+		logger.Printf("Failed to count recovery codes: %v", err)
+		return
+	}
+
+	if err := outbox.Enqueue(c.Request.Context(), utils.OutboxTopicEmail, &email.Message{
+		To:       user.Email,
+		Template: "recovery_code_used",
+		Locale:   requestLocale(c),
+		Data:     map[string]interface{}{"Username": user.Username, "Remaining": remaining},
+	}); err != nil {
+		logger.Printf("Failed to queue recovery code notice to user %s: %v", user.UserID, err)
+	}
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// csrfCookieName holds the token the consent form must echo back
+const csrfCookieName = "oauth_csrf"
+
+// Authorize renders the login and consent page for an authorization request
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req dto.AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.renderAuthorizeError(c, http.StatusBadRequest, "Invalid authorization request")
+		return
+	}
+
+	client, scope, ok := h.validateAuthorizeRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	h.renderAuthorizePage(c, http.StatusOK, client, scope, &req, "", "")
+}
+
+// AuthorizeDecision authenticates the user and, if they approve, redirects
+// back to the client with an authorization code. Only forms rendered by
+// Authorize are accepted, so other sites cannot post a decision for the user.
+func (h *OAuthHandler) AuthorizeDecision(c *gin.Context) {
+	var req dto.AuthorizeDecisionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderAuthorizeError(c, http.StatusBadRequest, "Invalid authorization request")
+		return
+	}
+	if !h.checkCSRF(c, req.CSRFToken) {
+		h.logger.Printf("Rejected authorization decision without a valid CSRF token from %s", c.ClientIP())
+		h.renderAuthorizeError(c, http.StatusForbidden, "The sign in form has expired, start again from the application")
+		return
+	}
+
+	client, scope, ok := h.validateAuthorizeRequest(c, &req.AuthorizeRequest)
+	if !ok {
+		return
+	}
+
+	if req.Decision != "approve" {
+		redirectWithError(c, req.RedirectURI, req.State, "access_denied", "the user denied the request")
+		return
+	}
+
+	// Authenticate the resource owner
+	var user models.User
+	if err := h.DB.Where("email = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			h.logger.Printf("Database error during authorization: %v", err)
+			h.renderAuthorizeError(c, http.StatusInternalServerError, "Failed to process sign in")
+			return
+		}
+		h.renderAuthorizePage(c, http.StatusUnauthorized, client, scope, &req.AuthorizeRequest, req.Email, "Invalid email or password")
+		return
+	}
+	if err := utils.ComparePasswords(user.Password, req.Password); err != nil {
+		h.logger.Printf("Failed password attempt for user %s during authorization", user.Email)
+		h.renderAuthorizePage(c, http.StatusUnauthorized, client, scope, &req.AuthorizeRequest, req.Email, "Invalid email or password")
+		return
+	}
+	if h.Cfg.Features.EnableMFA && !h.verifySecondFactor(c, client, scope, &req, &user) {
+		return
+	}
+	limit, allowed := utils.UnverifiedLoginScope(h.Cfg, &user)
+	if !allowed {
+		h.renderAuthorizePage(c, http.StatusForbidden, client, scope, &req.AuthorizeRequest, req.Email, "Verify your email address before signing in")
+		return
+	}
+	if limit != "" {
+		scope = utils.LimitScope(scope, limit)
+	}
+
+	code, err := h.codeStore.CreateCode(c.Request.Context(), models.AuthorizationCode{
+		ClientID:            client.ClientID,
+		UserID:              user.UserID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		ExpiresAt:           time.Now().Add(h.Cfg.OAuth.AuthorizationCodeTTL),
+	})
+	if err != nil {
+		h.logger.Printf("Failed to create authorization code: %v", err)
+		redirectWithError(c, req.RedirectURI, req.State, "server_error", "failed to issue authorization code")
+		return
+	}
+
+	if err := h.DB.Model(&user).Update("last_login", time.Now()).Error; err != nil {
+		h.logger.Printf("Failed to update last login: %v", err)
+	}
+	redirectWithParams(c, req.RedirectURI, url.Values{"code": {code}}, req.State)
+}
+
+// verifySecondFactor checks the second factor of users who have one and
+// renders the page again when it is missing or wrong. The page cannot run a
+// passkey assertion, so users whose only second factor is a passkey are
+// refused rather than let through on the password alone.
+func (h *OAuthHandler) verifySecondFactor(c *gin.Context, client *models.OAuthClient, scope string, req *dto.AuthorizeDecisionRequest, user *models.User) bool {
+	userID := user.UserID
+	methods, err := mfaMethods(c, h.Cfg, h.mfa, h.webauthn, userID)
+	if err != nil {
+		h.logger.Printf("Failed to check MFA enrollment: %v", err)
+		h.renderAuthorizeError(c, http.StatusInternalServerError, "Failed to process sign in")
+		return false
+	}
+	if len(methods) == 0 {
+		return true
+	}
+	if !hasMFAMethod(methods, mfaMethodTOTP) {
+		h.renderAuthorizePage(c, http.StatusForbidden, client, scope, &req.AuthorizeRequest, req.Email, "Your account is protected by a passkey, which this page does not support, sign in to the application with your passkey instead")
+		return false
+	}
+
+	if req.MFACode == "" {
+		h.renderAuthorizePage(c, http.StatusUnauthorized, client, scope, &req.AuthorizeRequest, req.Email, "Enter the code from your authenticator app")
+		return false
+	}
+	// The field takes either kind of code, authenticator codes are all digits
+	code, recoveryCode := req.MFACode, ""
+	if _, err := strconv.Atoi(code); err != nil || len(code) != utils.TOTPDigits {
+		code, recoveryCode = "", req.MFACode
+	}
+	usedRecovery, err := h.mfa.Verify(c.Request.Context(), userID, code, recoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrMFALocked):
+			h.renderAuthorizePage(c, http.StatusTooManyRequests, client, scope, &req.AuthorizeRequest, req.Email, "Too many failed attempts, try again later")
+		case errors.Is(err, utils.ErrMFAInvalidCode):
+			h.logger.Printf("Failed MFA attempt for user %s during authorization", userID)
+			h.renderAuthorizePage(c, http.StatusUnauthorized, client, scope, &req.AuthorizeRequest, req.Email, "Invalid authentication code")
+		default:
+			h.logger.Printf("Failed to verify MFA code: %v", err)
+			h.renderAuthorizeError(c, http.StatusInternalServerError, "Failed to process sign in")
+		}
+		return false
+	}
+	if usedRecovery {
+		notifyRecoveryCodeUsed(c, h.outbox, h.mfa, h.logger, user)
+	}
+	return true
+}
+
+// validateAuthorizeRequest checks the request and writes the error response
+// itself. Errors are only redirected once the redirect URI is known to belong
+// to the client (RFC 6749 section 4.1.2.1).
+func (h *OAuthHandler) validateAuthorizeRequest(c *gin.Context, req *dto.AuthorizeRequest) (*models.OAuthClient, string, bool) {
+	client, err := h.clientStore.GetClient(c.Request.Context(), req.ClientID)
+	if err != nil {
+		if !errors.Is(err, utils.ErrInvalidClient) {
+			h.logger.Printf("Failed to load client: %v", err)
+		}
+		h.renderAuthorizeError(c, http.StatusBadRequest, "Unknown client")
+		return nil, "", false
+	}
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !utils.ClientHasRedirectURI(client, req.RedirectURI) {
+		h.renderAuthorizeError(c, http.StatusBadRequest, "The redirect URI is not registered for this client")
+		return nil, "", false
+	}
+
+	if req.ResponseType != "code" {
+		redirectWithError(c, req.RedirectURI, req.State, "unsupported_response_type", "only the code response type is supported")
+		return nil, "", false
+	}
+	if !utils.ClientAllowsGrant(client, utils.GrantAuthorizationCode) {
+		redirectWithError(c, req.RedirectURI, req.State, "unauthorized_client", "the client may not use the authorization code grant")
+		return nil, "", false
+	}
+
+	scope, err := utils.ResolveScope(client, req.Scope)
+	if err != nil {
+		redirectWithError(c, req.RedirectURI, req.State, "invalid_scope", err.Error())
+		return nil, "", false
+	}
+
+	// PKCE is mandatory for public clients and only S256 is accepted
+	if req.CodeChallenge == "" && !client.Confidential {
+		redirectWithError(c, req.RedirectURI, req.State, "invalid_request", "code_challenge is required")
+		return nil, "", false
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != utils.PKCEMethodS256 {
+		redirectWithError(c, req.RedirectURI, req.State, "invalid_request", "code_challenge_method must be S256")
+		return nil, "", false
+	}
+
+	return client, scope, true
+}
+
+func (h *OAuthHandler) renderAuthorizePage(c *gin.Context, status int, client *models.OAuthClient, scope string, req *dto.AuthorizeRequest, email string, message string) {
+	csrfToken, err := utils.GenerateSecret(32)
+	if err != nil {
+		h.logger.Printf("Failed to generate CSRF token: %v", err)
+		h.renderAuthorizeError(c, http.StatusInternalServerError, "Failed to process sign in")
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken,
+		Path:     c.Request.URL.Path,
+		Secure:   strings.HasPrefix(publicURL(c, h.Cfg), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	h.renderPage(c, status, authorizePageData{
+		AppName:    h.Cfg.App.Name,
+		ClientName: client.Name,
+		Scopes:     strings.Fields(scope),
+		Error:      message,
+		Email:      email,
+		MFA:        h.Cfg.Features.EnableMFA,
+		Request:    req,
+		CSRFToken:  csrfToken,
+	})
+}
+
+// checkCSRF accepts a form posted from this server's own page, carrying the
+// token of the cookie set when the page was rendered
+func (h *OAuthHandler) checkCSRF(c *gin.Context, token string) bool {
+	if origin := c.GetHeader("Origin"); origin != "" {
+		base, err := url.Parse(publicURL(c, h.Cfg))
+		if err != nil || origin != base.Scheme+"://"+base.Host {
+			return false
+		}
+	}
+	cookie, err := c.Cookie(csrfCookieName)
+	if err != nil || cookie == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) == 1
+}
+
+func (h *OAuthHandler) renderAuthorizeError(c *gin.Context, status int, message string) {
+	h.renderPage(c, status, authorizePageData{AppName: h.Cfg.App.Name, Error: message})
+}
+
+func (h *OAuthHandler) renderPage(c *gin.Context, status int, data authorizePageData) {
+	// The page collects credentials, so it must never be framed or cached
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Status(status)
+	if err := authorizePage.Execute(c.Writer, data); err != nil {
+		h.logger.Printf("Failed to render authorization page: %v", err)
+	}
+}
+
+func redirectWithError(c *gin.Context, redirectURI string, state string, code string, description string) {
+	redirectWithParams(c, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	}, state)
+}
+
+func redirectWithParams(c *gin.Context, redirectURI string, params url.Values, state string) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid redirect URI")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
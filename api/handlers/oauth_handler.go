@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Token type hints and introspection token types (RFC 7009 section 2.1)
+const (
+	tokenTypeHintAccess  = "access_token"
+	tokenTypeHintRefresh = "refresh_token"
+)
+
+type OAuthHandler struct {
+	DB          *gorm.DB
+	Cfg         *config.Config
+	logger      *log.Logger
+	tokenStore  *utils.TokenStore
+	clientStore *utils.ClientStore
+	codeStore   *utils.CodeStore
+	rbac        *utils.RBACStore
+	mfa         *utils.MFAStore
+	webauthn    *utils.WebAuthnStore
+	outbox      *utils.OutboxStore
+	revocations utils.RevocationStore
+	refresher   *tokenRefresher
+}
+
+func NewOAuthHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *OAuthHandler {
+	h := &OAuthHandler{
+		DB:          db,
+		Cfg:         cfg,
+		logger:      log.New(log.Writer(), "OAuthHandler: ", log.LstdFlags),
+		tokenStore:  utils.NewTokenStore(db),
+		clientStore: utils.NewClientStore(db),
+		codeStore:   utils.NewCodeStore(db),
+		rbac:        utils.NewRBACStore(db),
+		mfa:         utils.NewMFAStore(db, &cfg.Security),
+		webauthn:    utils.NewWebAuthnStore(db, &cfg.WebAuthn),
+		outbox:      utils.NewOutboxStore(db),
+		revocations: revocations,
+	}
+	h.refresher = &tokenRefresher{db: db, cfg: cfg, logger: h.logger, tokenStore: h.tokenStore, revocations: revocations, rbac: h.rbac, orgs: utils.NewOrganizationStore(db)}
+	return h
+}
+
+// Introspect implements RFC 7662 token introspection
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	var req dto.TokenIntrospectionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	claims := h.inspectToken(c.Request.Context(), req.Token, req.TokenTypeHint)
+	if claims == nil {
+		c.JSON(http.StatusOK, dto.TokenIntrospectionResponse{Active: false})
+		return
+	}
+
+	h.logger.Printf("Client %s introspected token %s", client.ClientID, claims.ID)
+	c.JSON(http.StatusOK, introspectionResponse(claims))
+}
+
+// Revoke implements RFC 7009 token revocation. Unknown or already invalid
+// tokens, and tokens issued to another client or to no client at all, are
+// answered with 200 without revoking anything as the RFC requires.
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	var req dto.TokenRevocationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	claims := h.inspectToken(ctx, req.Token, req.TokenTypeHint)
+	if claims == nil {
+		c.Status(http.StatusOK)
+		return
+	}
+	if claims.ClientID == "" || claims.ClientID != client.ClientID {
+		h.logger.Printf("Client %s tried to revoke token %s it was not issued", client.ClientID, claims.ID)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	tokenType := tokenTypeOf(claims)
+	var err error
+	switch tokenType {
+	case tokenTypeHintAccess:
+		err = h.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	case tokenTypeHintRefresh:
+		// Revoking a refresh token ends the session it belongs to
+		err = h.tokenStore.RevokeTokenFamily(ctx, claims.ID)
+		if errors.Is(err, utils.ErrRefreshTokenNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		h.logger.Printf("Failed to revoke token: %v", err)
+		oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "failed to revoke token")
+		return
+	}
+
+	h.logger.Printf("Client %s revoked %s %s", client.ClientID, tokenType, claims.ID)
+	c.Status(http.StatusOK)
+}
+
+// inspectToken returns the claims of an active token, trying the hinted type
+// first, or nil when the token is not active
+func (h *OAuthHandler) inspectToken(ctx context.Context, token string, hint string) *utils.Claims {
+	order := []string{tokenTypeHintAccess, tokenTypeHintRefresh}
+	if hint == tokenTypeHintRefresh {
+		order = []string{tokenTypeHintRefresh, tokenTypeHintAccess}
+	}
+
+	for _, tokenType := range order {
+		var claims *utils.Claims
+		var err error
+		if tokenType == tokenTypeHintAccess {
+			claims, err = utils.ValidateAccessToken(token, &h.Cfg.JWT)
+		} else {
+			claims, err = utils.ValidateRefreshToken(token, &h.Cfg.JWT)
+		}
+		if err != nil {
+			continue
+		}
+		if h.isActive(ctx, claims) {
+			return claims
+		}
+		return nil
+	}
+	return nil
+}
+
+// tokenTypeOf names the type of a validated token the way hints and
+// introspection responses do
+func tokenTypeOf(claims *utils.Claims) string {
+	if claims.TokenType == utils.TokenTypeRefresh {
+		return tokenTypeHintRefresh
+	}
+	return tokenTypeHintAccess
+}
+
+// isActive checks a validly signed token against revocations and, for
+// refresh tokens, the refresh token store
+func (h *OAuthHandler) isActive(ctx context.Context, claims *utils.Claims) bool {
+	revoked, err := utils.TokenRevoked(ctx, h.revocations, claims)
+	if err != nil {
+		h.logger.Printf("Failed to check token revocation: %v", err)
+		return false
+	}
+	if revoked {
+		return false
+	}
+
+	if claims.TokenType == utils.TokenTypeRefresh {
+		if _, err := h.tokenStore.ValidateToken(ctx, claims.ID); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// authenticateClient verifies client credentials sent with HTTP Basic
+// authentication or in the request body, and writes the error response itself
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (*models.OAuthClient, bool) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		// RFC 6749 section 2.3.1 form-encodes credentials before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	if clientID == "" || secret == "" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication is required")
+		return nil, false
+	}
+
+	client, err := h.clientStore.Authenticate(c.Request.Context(), clientID, secret)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidClient) {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return nil, false
+		}
+		h.logger.Printf("Failed to authenticate client: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to authenticate client")
+		return nil, false
+	}
+	return client, true
+}
+
+func introspectionResponse(claims *utils.Claims) dto.TokenIntrospectionResponse {
+	response := dto.TokenIntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: tokenTypeOf(claims),
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		response.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		response.Nbf = claims.NotBefore.Unix()
+	}
+	return response
+}
+
+func oauthError(c *gin.Context, httpStatus int, code string, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(httpStatus, dto.OAuthErrorResponse{Error: code, ErrorDescription: description})
+}
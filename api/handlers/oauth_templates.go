@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"html/template"
+)
+
+// authorizePageData is rendered by the login and consent page
+type authorizePageData struct {
+	AppName    string
+	ClientName string
+	Scopes     []string
+	Error      string
+	Email      string
+	MFA        bool // ask for an authenticator code alongside the password
+	Request    interface{}
+	CSRFToken  string // echoes the CSRF cookie set with the page
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Sign in - {{.AppName}}</title>
+  <style>
+    body { font-family: system-ui, sans-serif; background: #f4f5f7; display: flex; justify-content: center; padding-top: 10vh; }
+    main { background: #fff; border-radius: 8px; padding: 2rem; width: 22rem; box-shadow: 0 1px 4px rgba(0,0,0,.1); }
+    label { display: block; margin-top: 1rem; font-size: .9rem; }
+    input[type=email], input[type=password], input[type=text] { width: 100%; padding: .5rem; box-sizing: border-box; }
+    .error { color: #b00020; }
+    .actions { display: flex; gap: .5rem; margin-top: 1.5rem; }
+    button { flex: 1; padding: .6rem; }
+  </style>
+</head>
+<body>
+<main>
+  <h1>{{.AppName}}</h1>
+  {{if .ClientName}}<p><strong>{{.ClientName}}</strong> wants to access your account.</p>{{end}}
+  {{if .Scopes}}
+  <p>It is requesting:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{end}}
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+  {{if .Request}}
+  <form method="post">
+    {{with .Request}}
+    <input type="hidden" name="response_type" value="{{.ResponseType}}">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+    <input type="hidden" name="nonce" value="{{.Nonce}}">
+    {{end}}
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+    <label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+    {{if .MFA}}<label>Authenticator or recovery code, if enabled <input type="text" name="mfa_code" autocomplete="one-time-code"></label>{{end}}
+    <div class="actions">
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+      <button type="submit" name="decision" value="approve">Sign in and allow</button>
+    </div>
+  </form>
+  {{end}}
+</main>
+</body>
+</html>
+`))
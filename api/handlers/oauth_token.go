@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Token implements the RFC 6749 token endpoint for the authorization code,
+// refresh token and client credentials grants
+func (h *OAuthHandler) Token(c *gin.Context) {
+	client, ok := h.identifyClient(c)
+	if !ok {
+		return
+	}
+
+	var req dto.OAuthTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		return
+	}
+
+	switch req.GrantType {
+	case utils.GrantAuthorizationCode, utils.GrantRefreshToken, utils.GrantClientCredentials:
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "the grant type is not supported")
+		return
+	}
+	if !utils.ClientAllowsGrant(client, req.GrantType) {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", "the client may not use this grant type")
+		return
+	}
+
+	switch req.GrantType {
+	case utils.GrantAuthorizationCode:
+		h.exchangeCode(c, client, &req)
+	case utils.GrantRefreshToken:
+		h.exchangeRefreshToken(c, client, &req)
+	case utils.GrantClientCredentials:
+		h.exchangeClientCredentials(c, client, &req)
+	}
+}
+
+// exchangeCode redeems an authorization code for a new session
+func (h *OAuthHandler) exchangeCode(c *gin.Context, client *models.OAuthClient, req *dto.OAuthTokenRequest) {
+	ctx := c.Request.Context()
+	if req.Code == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "code is required")
+		return
+	}
+
+	sessionID := uuid.New()
+	authorization, err := h.codeStore.ConsumeCode(ctx, req.Code, client.ClientID, req.RedirectURI, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrAuthorizationCodeReused):
+			h.logger.Printf("Authorization code replayed by client %s from %s, issued session revoked", client.ClientID, c.ClientIP())
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or expired")
+		case errors.Is(err, utils.ErrInvalidAuthorizationCode):
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or expired")
+		default:
+			h.logger.Printf("Failed to consume authorization code: %v", err)
+			oauthError(c, http.StatusInternalServerError, "server_error", "failed to redeem authorization code")
+		}
+		return
+	}
+
+	// A code issued with a challenge can only be redeemed with its verifier
+	if authorization.CodeChallenge != "" &&
+		!utils.VerifyPKCE(authorization.CodeChallenge, authorization.CodeChallengeMethod, req.CodeVerifier) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(ctx).First(&user, "user_id = ?", authorization.UserID).Error; err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or expired")
+		return
+	}
+
+	// Clients act within the consented scope, never with the user's roles
+	tokens, err := utils.GenerateTokenPair(utils.TokenOptions{
+		UserID:    user.UserID.String(),
+		Username:  user.Username,
+		SessionID: sessionID.String(),
+		ClientID:  client.ClientID,
+		Scope:     authorization.Scope,
+	}, &h.Cfg.JWT)
+	if err != nil {
+		h.logger.Printf("Failed to generate tokens: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+
+	if err := h.tokenStore.CreateSession(ctx, sessionID, user.UserID, sessionInfo(c, client.Name), tokens, h.Cfg.Security.MaxSessionsPerUser); err != nil {
+		h.logger.Printf("Failed to create session: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+
+	response := h.tokenResponse(client, tokens, authorization.Scope)
+
+	// OpenID Connect requests also get an ID token
+	if utils.HasScope(authorization.Scope, utils.ScopeOpenID) {
+		response.IDToken, err = utils.GenerateIDToken(&user, utils.IDTokenOptions{
+			ClientID:  client.ClientID,
+			Scope:     authorization.Scope,
+			Nonce:     authorization.Nonce,
+			SessionID: sessionID.String(),
+			AuthTime:  authorization.CreatedAt,
+		}, &h.Cfg.JWT, h.Cfg.OAuth.IDTokenTTL)
+		if err != nil {
+			h.logger.Printf("Failed to generate id token: %v", err)
+			oauthError(c, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+			return
+		}
+	}
+
+	h.logger.Printf("Client %s redeemed an authorization code for user %s", client.ClientID, user.UserID)
+	writeTokenResponse(c, response)
+}
+
+// exchangeRefreshToken rotates a refresh token issued to the client
+func (h *OAuthHandler) exchangeRefreshToken(c *gin.Context, client *models.OAuthClient, req *dto.OAuthTokenRequest) {
+	if req.RefreshToken == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	tokens, claims, err := h.refresher.refresh(c, req.RefreshToken, client.ClientID, nil)
+	if err != nil {
+		if errors.Is(err, errRefreshTokenRejected) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or expired")
+			return
+		}
+		h.logger.Printf("Failed to refresh token: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to refresh token")
+		return
+	}
+
+	writeTokenResponse(c, h.tokenResponse(client, tokens, claims.Scope))
+}
+
+// exchangeClientCredentials issues a service access token to a confidential
+// client acting on its own behalf (RFC 6749 section 4.4)
+func (h *OAuthHandler) exchangeClientCredentials(c *gin.Context, client *models.OAuthClient, req *dto.OAuthTokenRequest) {
+	if !client.Confidential {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", "public clients cannot use the client credentials grant")
+		return
+	}
+
+	scope, err := utils.ResolveScope(client, req.Scope)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	tokens, err := utils.GenerateServiceToken(client.ClientID, scope, &h.Cfg.JWT)
+	if err != nil {
+		h.logger.Printf("Failed to generate service token: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+
+	h.logger.Printf("Issued service token to client %s", client.ClientID)
+	writeTokenResponse(c, h.tokenResponse(client, tokens, scope))
+}
+
+func (h *OAuthHandler) tokenResponse(client *models.OAuthClient, tokens *utils.TokenDetails, scope string) dto.OAuthTokenResponse {
+	response := dto.OAuthTokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(tokens.AtExpires).Seconds()),
+		Scope:       scope,
+	}
+	// Clients without the refresh grant could not use the token anyway
+	if utils.ClientAllowsGrant(client, utils.GrantRefreshToken) {
+		response.RefreshToken = tokens.RefreshToken
+	}
+	return response
+}
+
+func writeTokenResponse(c *gin.Context, response dto.OAuthTokenResponse) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, response)
+}
+
+// identifyClient authenticates confidential clients and looks up public
+// clients, which only send their client_id (RFC 6749 section 2.3)
+func (h *OAuthHandler) identifyClient(c *gin.Context) (*models.OAuthClient, bool) {
+	if _, _, ok := c.Request.BasicAuth(); ok || c.PostForm("client_secret") != "" {
+		return h.authenticateClient(c)
+	}
+
+	clientID := c.PostForm("client_id")
+	if clientID == "" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication is required")
+		return nil, false
+	}
+
+	client, err := h.clientStore.GetClient(c.Request.Context(), clientID)
+	if err != nil && !errors.Is(err, utils.ErrInvalidClient) {
+		h.logger.Printf("Failed to load client: %v", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to authenticate client")
+		return nil, false
+	}
+	if err != nil || client.Confidential {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// UserInfo implements the OpenID Connect userinfo endpoint. The claims
+// released are limited by the scope of the access token.
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	scope := c.GetString("scope")
+	if !utils.HasScope(scope, utils.ScopeOpenID) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		oauthError(c, http.StatusForbidden, "insufficient_scope", "the access token was not granted the openid scope")
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", c.GetString("userID")).Error; err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "the user no longer exists")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.UserInfo{
+		Subject:    user.UserID.String(),
+		UserClaims: utils.NewUserClaims(&user, scope),
+	})
+}
@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrganizationHandler serves organizations, their members and invitations.
+// Endpoints acting on one organization require a token switched to it.
+type OrganizationHandler struct {
+	DB        *gorm.DB
+	Cfg       *config.Config
+	logger    *log.Logger
+	orgs      *utils.OrganizationStore
+	outbox    *utils.OutboxStore
+	refresher *tokenRefresher
+}
+
+func NewOrganizationHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *OrganizationHandler {
+	h := &OrganizationHandler{
+		DB:     db,
+		Cfg:    cfg,
+		logger: log.New(log.Writer(), "OrganizationHandler: ", log.LstdFlags),
+		orgs:   utils.NewOrganizationStore(db),
+		outbox: utils.NewOutboxStore(db),
+	}
+	h.refresher = &tokenRefresher{db: db, cfg: cfg, logger: h.logger, tokenStore: utils.NewTokenStore(db), revocations: revocations, rbac: utils.NewRBACStore(db), orgs: h.orgs}
+	return h
+}
+
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	org, err := h.orgs.CreateOrganization(c.Request.Context(), req.Name, userID)
+	if err != nil {
+		h.logger.Printf("Failed to create organization: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to create organization")
+		return
+	}
+
+	rb.Success(http.StatusCreated, dto.OrganizationResponse{
+		ID:        org.ID,
+		Name:      org.Name,
+		Role:      utils.OrgRoleOwner,
+		CreatedAt: org.CreatedAt,
+	}, "Organization created successfully")
+}
+
+func (h *OrganizationHandler) ListOrganizations(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	orgs, err := h.orgs.ListUserOrganizations(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list organizations: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch organizations")
+		return
+	}
+
+	response := make([]dto.OrganizationResponse, 0, len(orgs))
+	for _, org := range orgs {
+		joinedAt := org.JoinedAt
+		response = append(response, dto.OrganizationResponse{
+			ID:        org.ID,
+			Name:      org.Name,
+			Role:      org.Role,
+			JoinedAt:  &joinedAt,
+			CreatedAt: org.CreatedAt,
+		})
+	}
+
+	rb.Success(http.StatusOK, response, "Organizations retrieved successfully")
+}
+
+// SwitchOrganization rotates the caller's refresh token into a token pair
+// scoped to the organization. The refresh token must belong to the session
+// of the access token used for the request.
+func (h *OrganizationHandler) SwitchOrganization(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	var req dto.SwitchOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	claims, err := utils.ValidateRefreshToken(req.RefreshToken, &h.Cfg.JWT)
+	if err != nil || claims.Subject != c.GetString("userID") || claims.SessionID != c.GetString("sessionID") {
+		rb.Error(http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+
+	tokens, _, err := h.refresher.refresh(c, req.RefreshToken, claims.ClientID, &orgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRefreshTokenRejected):
+			rb.Error(http.StatusUnauthorized, "Invalid or expired refresh token")
+		case errors.Is(err, errNotOrgMember):
+			rb.Error(http.StatusForbidden, "You are not a member of this organization")
+		default:
+			h.logger.Printf("Failed to switch organization: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to switch organization")
+		}
+		return
+	}
+
+	rb.Success(http.StatusOK, dto.TokenRefreshResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(h.Cfg.JWT.AccessTokenExpiry.Seconds()),
+	}, "Organization switched successfully")
+}
+
+func (h *OrganizationHandler) ListMembers(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	if _, ok := h.callerRole(c, rb, orgID, "Failed to fetch members"); !ok {
+		return
+	}
+
+	members, err := h.orgs.ListMembers(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Printf("Failed to list members: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch members")
+		return
+	}
+
+	response := make([]dto.MemberResponse, 0, len(members))
+	for _, member := range members {
+		response = append(response, dto.MemberResponse{
+			UserID:   member.UserID,
+			Username: member.Username,
+			Email:    member.Email,
+			Role:     member.Role,
+			JoinedAt: member.JoinedAt,
+		})
+	}
+
+	rb.Success(http.StatusOK, response, "Members retrieved successfully")
+}
+
+// RemoveMember removes a member from the organization. Members may always
+// leave, removing someone else needs the owner or admin role and only owners
+// can remove other owners.
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if memberID.String() != c.GetString("userID") {
+		callerRole, ok := h.callerRole(c, rb, orgID, "Failed to remove member")
+		if !ok {
+			return
+		}
+		if callerRole != utils.OrgRoleOwner && callerRole != utils.OrgRoleAdmin {
+			rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners and admins can remove members")
+			return
+		}
+		membership, err := h.orgs.GetMembership(c.Request.Context(), orgID, memberID)
+		if err != nil {
+			h.organizationError(rb, err, "Failed to remove member")
+			return
+		}
+		if membership.Role == utils.OrgRoleOwner && callerRole != utils.OrgRoleOwner {
+			rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners can remove other owners")
+			return
+		}
+	}
+
+	if err := h.orgs.RemoveMember(c.Request.Context(), orgID, memberID); err != nil {
+		h.organizationError(rb, err, "Failed to remove member")
+		return
+	}
+
+	h.logger.Printf("User %s removed user %s from organization %s", c.GetString("userID"), memberID, orgID)
+	rb.Success(http.StatusOK, nil, "Member removed successfully")
+}
+
+// CreateInvitation invites an email address to the organization. The
+// acceptance link is emailed to the address only.
+func (h *OrganizationHandler) CreateInvitation(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	callerRole, ok := h.callerRole(c, rb, orgID, "Failed to create invitation")
+	if !ok {
+		return
+	}
+	if callerRole != utils.OrgRoleOwner && callerRole != utils.OrgRoleAdmin {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners and admins can invite members")
+		return
+	}
+	if req.Role == utils.OrgRoleOwner && callerRole != utils.OrgRoleOwner {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners can invite owners")
+		return
+	}
+
+	var invitation *models.Invitation
+	err = h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var org models.Organization
+		if err := tx.First(&org, "id = ?", orgID).Error; err != nil {
+			return fmt.Errorf("failed to load organization: %w", err)
+		}
+		var inviter models.User
+		if err := tx.First(&inviter, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to load inviter: %w", err)
+		}
+
+		var token string
+		var err error
+		if invitation, token, err = h.orgs.WithTx(tx).CreateInvitation(c.Request.Context(), orgID, req.Email, req.Role, userID, h.Cfg.Organizations.InvitationTTL); err != nil {
+			return err
+		}
+		return h.outbox.WithTx(tx).Enqueue(c.Request.Context(), utils.OutboxTopicEmail, invitationEmail(c, h.Cfg, &org, &inviter, invitation, token))
+	})
+	if err != nil {
+		h.organizationError(rb, err, "Failed to create invitation")
+		return
+	}
+
+	rb.Success(http.StatusCreated, invitationResponse(invitation), "Invitation sent successfully")
+}
+
+func (h *OrganizationHandler) ListInvitations(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	callerRole, ok := h.callerRole(c, rb, orgID, "Failed to fetch invitations")
+	if !ok {
+		return
+	}
+	if callerRole != utils.OrgRoleOwner && callerRole != utils.OrgRoleAdmin {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners and admins can list invitations")
+		return
+	}
+
+	invitations, err := h.orgs.ListInvitations(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Printf("Failed to list invitations: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch invitations")
+		return
+	}
+
+	response := make([]dto.InvitationResponse, 0, len(invitations))
+	for i := range invitations {
+		response = append(response, invitationResponse(&invitations[i]))
+	}
+
+	rb.Success(http.StatusOK, response, "Invitations retrieved successfully")
+}
+
+func (h *OrganizationHandler) RevokeInvitation(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+	invitationID, err := uuid.Parse(c.Param("invitationId"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid invitation ID")
+		return
+	}
+	callerRole, ok := h.callerRole(c, rb, orgID, "Failed to revoke invitation")
+	if !ok {
+		return
+	}
+	if callerRole != utils.OrgRoleOwner && callerRole != utils.OrgRoleAdmin {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "Only owners and admins can revoke invitations")
+		return
+	}
+
+	if err := h.orgs.RevokeInvitation(c.Request.Context(), orgID, invitationID); err != nil {
+		h.organizationError(rb, err, "Failed to revoke invitation")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Invitation revoked successfully")
+}
+
+// AcceptInvitation adds the caller to the organization they were invited to
+func (h *OrganizationHandler) AcceptInvitation(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to accept invitation")
+		return
+	}
+
+	membership, err := h.orgs.AcceptInvitation(c.Request.Context(), req.Token, &user)
+	if err != nil {
+		h.organizationError(rb, err, "Failed to accept invitation")
+		return
+	}
+
+	rb.Success(http.StatusOK, dto.MemberResponse{
+		UserID:   user.UserID,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     membership.Role,
+		JoinedAt: membership.CreatedAt,
+	}, "Invitation accepted successfully")
+}
+
+// callerRole reads the caller's current role in the organization. Every
+// endpoint of an organization uses it rather than the org_role claim, which
+// keeps a demoted or removed member's old role until the token expires.
+func (h *OrganizationHandler) callerRole(c *gin.Context, rb *dto.ResponseBuilder, orgID uuid.UUID, message string) (string, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return "", false
+	}
+
+	membership, err := h.orgs.GetMembership(c.Request.Context(), orgID, userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrMembershipNotFound) {
+			rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "You are no longer a member of this organization")
+			return "", false
+		}
+		h.logger.Printf("%s: %v", message, err)
+		rb.Error(http.StatusInternalServerError, message)
+		return "", false
+	}
+	return membership.Role, true
+}
+
+// organizationError maps the store errors to responses
+func (h *OrganizationHandler) organizationError(rb *dto.ResponseBuilder, err error, message string) {
+	switch {
+	case errors.Is(err, utils.ErrMembershipNotFound):
+		rb.Error(http.StatusNotFound, "Member not found")
+	case errors.Is(err, utils.ErrInvitationNotFound):
+		rb.Error(http.StatusNotFound, "Invitation not found")
+	case errors.Is(err, utils.ErrInvitationInvalid):
+		rb.Error(http.StatusBadRequest, "Invitation is invalid or expired")
+	case errors.Is(err, utils.ErrInvitationWrongEmail):
+		rb.Error(http.StatusForbidden, "Invitation was sent to a different email address")
+	case errors.Is(err, utils.ErrAlreadyMember):
+		rb.Error(http.StatusConflict, "Already a member of the organization")
+	case errors.Is(err, utils.ErrLastOwner):
+		rb.Error(http.StatusConflict, "The last owner cannot leave the organization")
+	case errors.Is(err, utils.ErrInvalidOrgRole):
+		rb.Error(http.StatusBadRequest, "Invalid organization role")
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		rb.Error(http.StatusInternalServerError, message)
+	}
+}
+
+// invitationEmail builds the message that carries an invitation token
+func invitationEmail(c *gin.Context, cfg *config.Config, org *models.Organization, inviter *models.User, invitation *models.Invitation, token string) *email.Message {
+	var link string
+	if linkURL := cfg.Organizations.LinkURL; linkURL != "" {
+		link = fmt.Sprintf("%s?token=%s", linkURL, url.QueryEscape(token))
+	}
+
+	return &email.Message{
+		To:       invitation.Email,
+		Template: "org_invitation",
+		Locale:   requestLocale(c),
+		Data: map[string]interface{}{
+			"Organization": org.Name,
+			"Inviter":      inviter.Username,
+			"Role":         invitation.Role,
+			"Token":        token,
+			"Link":         link,
+			"Days":         int(cfg.Organizations.InvitationTTL.Hours() / 24),
+		},
+	}
+}
+
+func invitationResponse(invitation *models.Invitation) dto.InvitationResponse {
+	return dto.InvitationResponse{
+		ID:             invitation.ID,
+		OrganizationID: invitation.OrganizationID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		ExpiresAt:      invitation.ExpiresAt,
+		CreatedAt:      invitation.CreatedAt,
+	}
+}
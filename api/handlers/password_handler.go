@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PasswordHandler serves password changes and resets
+type PasswordHandler struct {
+	DB          *gorm.DB
+	Cfg         *config.Config
+	logger      *log.Logger
+	tokenStore  *utils.TokenStore
+	revocations utils.RevocationStore
+	resets      *utils.PasswordResetStore
+	pats        *utils.PersonalAccessTokenStore
+	outbox      *utils.OutboxStore
+}
+
+func NewPasswordHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *PasswordHandler {
+	return &PasswordHandler{
+		DB:          db,
+		Cfg:         cfg,
+		logger:      log.New(log.Writer(), "PasswordHandler: ", log.LstdFlags),
+		tokenStore:  utils.NewTokenStore(db),
+		revocations: revocations,
+		resets:      utils.NewPasswordResetStore(db, &cfg.Security.PasswordReset),
+		pats:        utils.NewPersonalAccessTokenStore(db),
+		outbox:      utils.NewOutboxStore(db),
+	}
+}
+
+// ChangePassword replaces the password of the signed in user after checking
+// the current one. Other sessions can be signed out at the same time, which
+// revokes their access tokens and the user's personal access tokens too.
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.ChangePasswordRequest
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	if err := utils.ComparePasswords(user.Password, req.CurrentPassword); err != nil {
+		h.logger.Printf("Failed password attempt for user %s while changing password", user.UserID)
+		rb.Error(http.StatusForbidden, "Current password is incorrect")
+		return
+	}
+	if err := utils.ComparePasswords(user.Password, req.NewPassword); err == nil {
+		rb.Error(http.StatusBadRequest, "New password must be different from the current password")
+		return
+	}
+	if err := utils.ValidatePassword(req.NewPassword, &h.Cfg.Security); err != nil {
+		rb.Error(http.StatusBadRequest, fmt.Sprintf("invalid password: %v", err))
+		return
+	}
+	hashedPassword, err := utils.HashPassword(req.NewPassword, &h.Cfg.Security)
+	if err != nil {
+		h.logger.Printf("Failed to hash password: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	// Signed in without a session there is none to keep, and every token of
+	// the user is revoked instead
+	sessionID, sessionErr := uuid.Parse(c.GetString("sessionID"))
+	var revoked []uuid.UUID
+	err = h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		if req.RevokeOtherSessions {
+			tokens := h.tokenStore.WithTx(tx)
+			if sessionErr != nil {
+				if err := tokens.RevokeAllSessions(c.Request.Context(), user.UserID); err != nil {
+					return err
+				}
+			} else {
+				ids, err := tokens.RevokeOtherSessions(c.Request.Context(), user.UserID, sessionID)
+				if err != nil {
+					return err
+				}
+				revoked = ids
+			}
+			if _, err := h.pats.WithTx(tx).RevokeAllTokens(c.Request.Context(), user.UserID); err != nil {
+				return err
+			}
+		}
+
+		outbox := h.outbox.WithTx(tx)
+		if err := outbox.Enqueue(c.Request.Context(), utils.OutboxTopicEmail, &email.Message{
+			To:       user.Email,
+			Template: "password_changed",
+			Locale:   requestLocale(c),
+			Data:     map[string]interface{}{"Username": user.Username},
+		}); err != nil {
+			return err
+		}
+		return outbox.EnqueueEvent(c.Request.Context(), utils.EventPasswordChanged, user.UserID, nil)
+	})
+	if err != nil {
+		h.logger.Printf("Failed to change password: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	// Access tokens of the revoked sessions stop working as well
+	if req.RevokeOtherSessions {
+		if sessionErr != nil {
+			err = h.revocations.RevokeUserTokens(c.Request.Context(), user.UserID, time.Now())
+		}
+		for _, id := range revoked {
+			if err = revokeSessionTokens(c, h.Cfg, h.revocations, id); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			h.logger.Printf("Failed to revoke access tokens of user %s: %v", user.UserID, err)
+			rb.Error(http.StatusInternalServerError, "Password changed but other sessions could not be signed out")
+			return
+		}
+	}
+
+	h.logger.Printf("User %s changed their password", user.UserID)
+	rb.Success(http.StatusOK, gin.H{"revoked_sessions": len(revoked)}, "Password changed successfully")
+}
+
+// ForgotPassword emails a reset token. The response is the same whether or
+// not the address has an account.
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.ForgotPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	var user models.User
+	err := h.DB.WithContext(c.Request.Context()).Where("email = ?", strings.ToLower(req.Email)).First(&user).Error
+	switch {
+	case err == nil:
+		h.sendResetToken(c, &user)
+	case err != gorm.ErrRecordNotFound:
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "If an account exists for this email, a password reset link has been sent")
+}
+
+// sendResetToken issues a reset token and queues its email. Failures are only
+// logged so the response cannot tell them apart from an unknown address, and
+// the email is sent in the background so its delivery time cannot either.
+func (h *PasswordHandler) sendResetToken(c *gin.Context, user *models.User) {
+	ctx := c.Request.Context()
+	err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		token, err := h.resets.WithTx(tx).CreateToken(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if linkURL := h.Cfg.Security.PasswordReset.LinkURL; linkURL != "" {
+			link = fmt.Sprintf("%s?token=%s", linkURL, url.QueryEscape(token))
+		}
+
+		return h.outbox.WithTx(tx).Enqueue(ctx, utils.OutboxTopicEmail, &email.Message{
+			To:       user.Email,
+			Template: "password_reset",
+			Locale:   requestLocale(c),
+			Data: map[string]interface{}{
+				"Username": user.Username,
+				"Token":    token,
+				"Link":     link,
+				"Minutes":  int(h.Cfg.Security.PasswordReset.TokenTTL.Minutes()),
+			},
+		})
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrPasswordResetThrottled) {
+			h.logger.Printf("Skipped password reset for user %s, one was sent recently", user.UserID)
+			return
+		}
+		h.logger.Printf("Failed to issue reset token for user %s: %v", user.UserID, err)
+	}
+}
+
+// ResetPassword sets a new password with a reset token and signs the user out
+// everywhere
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	if err := utils.ValidatePassword(req.Password, &h.Cfg.Security); err != nil {
+		rb.Error(http.StatusBadRequest, fmt.Sprintf("invalid password: %v", err))
+		return
+	}
+	hashedPassword, err := utils.HashPassword(req.Password, &h.Cfg.Security)
+	if err != nil {
+		h.logger.Printf("Failed to hash password: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	var userID uuid.UUID
+	err = h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if userID, err = h.resets.WithTx(tx).RedeemToken(c.Request.Context(), req.Token); err != nil {
+			return err
+		}
+		// The token arrived by email, which proves the address as well
+		if err := tx.Model(&models.User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"password":          hashedPassword,
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		return h.tokenStore.WithTx(tx).RevokeAllSessions(c.Request.Context(), userID)
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrPasswordResetInvalid) {
+			rb.Error(http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		h.logger.Printf("Failed to reset password: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	// Access tokens issued before the reset stop working as well
+	if err := h.revocations.RevokeUserTokens(c.Request.Context(), userID, time.Now()); err != nil {
+		h.logger.Printf("Failed to revoke access tokens of user %s: %v", userID, err)
+	}
+
+	h.logger.Printf("User %s reset their password", userID)
+	rb.Success(http.StatusOK, nil, "Password reset successfully, sign in with the new password")
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PersonalTokenHandler struct {
+	DB             *gorm.DB
+	Cfg            *config.Config
+	logger         *log.Logger
+	personalTokens *utils.PersonalAccessTokenStore
+}
+
+func NewPersonalTokenHandler(db *gorm.DB, cfg *config.Config, personalTokens *utils.PersonalAccessTokenStore) *PersonalTokenHandler {
+	return &PersonalTokenHandler{
+		DB:             db,
+		Cfg:            cfg,
+		logger:         log.New(log.Writer(), "PersonalTokenHandler: ", log.LstdFlags),
+		personalTokens: personalTokens,
+	}
+}
+
+func (h *PersonalTokenHandler) CreateToken(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// A first-party token limited to a scope must not escape it
+	if c.GetString("scope") != "" {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientScope, "Scope limited tokens cannot create other tokens")
+		return
+	}
+
+	var req dto.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		rb.Error(http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	token, plain, err := h.personalTokens.CreateToken(c.Request.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		h.logger.Printf("Failed to create personal access token: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	rb.Success(http.StatusCreated, dto.CreatedPersonalAccessTokenResponse{
+		PersonalAccessTokenResponse: personalTokenResponse(token),
+		Token:                       plain,
+	}, "Token created successfully, store it now as it cannot be shown again")
+}
+
+func (h *PersonalTokenHandler) ListTokens(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokens, err := h.personalTokens.ListTokens(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list personal access tokens: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch tokens")
+		return
+	}
+
+	response := make([]dto.PersonalAccessTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, personalTokenResponse(&tokens[i]))
+	}
+
+	rb.Success(http.StatusOK, response, "Tokens retrieved successfully")
+}
+
+func (h *PersonalTokenHandler) RevokeToken(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := h.personalTokens.RevokeToken(c.Request.Context(), userID, tokenID); err != nil {
+		if errors.Is(err, utils.ErrPersonalAccessTokenNotFound) {
+			rb.Error(http.StatusNotFound, "Token not found")
+			return
+		}
+		h.logger.Printf("Failed to revoke personal access token: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Token revoked successfully")
+}
+
+func personalTokenResponse(token *models.PersonalAccessToken) dto.PersonalAccessTokenResponse {
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return dto.PersonalAccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		TokenHint:  token.TokenHint,
+		Scopes:     scopes,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/api/middlewares"
+	"github.com/HersheyPlus/go-auth/api/validators"
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/email"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ProfileHandler serves changes to the profile of the signed in user
+type ProfileHandler struct {
+	DB            *gorm.DB
+	Cfg           *config.Config
+	logger        *log.Logger
+	profiles      *utils.ProfileStore
+	verifications *utils.EmailVerificationStore
+	outbox        *utils.OutboxStore
+	audit         *utils.AuditStore
+	verifier      *secondFactorVerifier
+}
+
+func NewProfileHandler(db *gorm.DB, cfg *config.Config) *ProfileHandler {
+	h := &ProfileHandler{
+		DB:            db,
+		Cfg:           cfg,
+		logger:        log.New(log.Writer(), "ProfileHandler: ", log.LstdFlags),
+		profiles:      utils.NewProfileStore(db),
+		verifications: utils.NewEmailVerificationStore(db, &cfg.Security.EmailVerification),
+		outbox:        utils.NewOutboxStore(db),
+		audit:         utils.NewAuditStore(db),
+	}
+	h.verifier = newSecondFactorVerifier(db, cfg, h.logger, utils.NewMFAStore(db, &cfg.Security))
+	return h
+}
+
+// UpdateProfile changes the fields present in the request. A new email address
+// needs the user to sign in again with the password and second factor, and
+// only takes effect once it is confirmed with the link sent to it.
+func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
+	rb := dto.NewResponse(c)
+	var req dto.UserUpdateRequest
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	if err := validators.ValidateUpdateFields(&req); err != nil {
+		rb.Error(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Names can be changed by any token with the scope, the address that
+	// receives password resets only from a first-party login
+	if req.Email != nil && !middlewares.IsInteractiveSession(c) {
+		rb.ErrorWithCode(http.StatusForbidden, dto.CodeInteractiveRequired, "The email address can only be changed after signing in to the application")
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	updates := map[string]interface{}{}
+	var changed []string
+	if req.Username != nil && *req.Username != user.Username {
+		updates["username"] = *req.Username
+		changed = append(changed, "username")
+	}
+	if req.FirstName != nil && (user.FirstName == nil || *req.FirstName != *user.FirstName) {
+		updates["first_name"] = *req.FirstName
+		changed = append(changed, "first_name")
+	}
+	if req.LastName != nil && (user.LastName == nil || *req.LastName != *user.LastName) {
+		updates["last_name"] = *req.LastName
+		changed = append(changed, "last_name")
+	}
+	if req.Phone != nil && *req.Phone != user.Phone {
+		updates["phone"] = *req.Phone
+		changed = append(changed, "phone")
+	}
+	var newEmail string
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		newEmail = strings.ToLower(*req.Email)
+
+		// Whoever controls the address can reset the password, so a stolen
+		// session alone must not be enough to move it
+		if req.CurrentPassword == "" {
+			rb.Error(http.StatusBadRequest, "The current password is required to change the email address")
+			return
+		}
+		if err := utils.ComparePasswords(user.Password, req.CurrentPassword); err != nil {
+			h.logger.Printf("Failed password attempt for user %s while changing email", user.UserID)
+			rb.Error(http.StatusForbidden, "Password is incorrect")
+			return
+		}
+		if h.Cfg.Features.EnableMFA && !h.verifier.verify(c, rb, &user, &req.SecondFactorAnswer) {
+			return
+		}
+	}
+
+	if len(changed) == 0 && newEmail == "" {
+		rb.Success(http.StatusOK, profileResponse(&user), "Profile is unchanged")
+		return
+	}
+
+	err := h.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		ctx := c.Request.Context()
+		profiles := h.profiles.WithTx(tx)
+		outbox := h.outbox.WithTx(tx)
+
+		if _, ok := updates["username"]; ok {
+			if err := profiles.ClaimUsername(ctx, user.UserID, *req.Username); err != nil {
+				return err
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to update profile: %w", err)
+			}
+		}
+
+		details := map[string]interface{}{"fields": changed}
+		if newEmail != "" {
+			if err := profiles.CheckEmailAvailable(ctx, user.UserID, newEmail); err != nil {
+				return err
+			}
+			token, err := h.verifications.WithTx(tx).CreateToken(ctx, user.UserID, newEmail)
+			if err != nil {
+				return err
+			}
+			if err := outbox.Enqueue(ctx, utils.OutboxTopicEmail, emailChangeEmail(c, h.Cfg, &user, newEmail, token)); err != nil {
+				return err
+			}
+			// Warn the current address in case the change is not the user's
+			if err := outbox.Enqueue(ctx, utils.OutboxTopicEmail, &email.Message{
+				To:       user.Email,
+				Template: "email_change_requested",
+				Locale:   requestLocale(c),
+				Data:     map[string]interface{}{"Username": user.Username, "Email": newEmail},
+			}); err != nil {
+				return err
+			}
+			details["requested_email"] = newEmail
+		}
+
+		if err := h.audit.WithTx(tx).Record(ctx, models.AuditEvent{
+			UserID:    user.UserID,
+			Action:    utils.AuditProfileUpdated,
+			Details:   details,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}); err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		return outbox.EnqueueEvent(ctx, utils.EventProfileUpdated, user.UserID, map[string]interface{}{"fields": changed})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrUsernameTaken):
+			rb.Error(http.StatusConflict, "Username is already taken")
+		case errors.Is(err, utils.ErrEmailTaken):
+			rb.Error(http.StatusConflict, "Email address is already in use by another account")
+		case errors.Is(err, utils.ErrEmailVerificationThrottled):
+			rb.Error(http.StatusTooManyRequests, "A confirmation email was sent recently, try again in a minute")
+		default:
+			h.logger.Printf("Failed to update profile: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to update profile")
+		}
+		return
+	}
+
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		h.logger.Printf("Failed to fetch updated user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Profile updated but failed to fetch it")
+		return
+	}
+
+	message := "Profile updated successfully"
+	if newEmail != "" {
+		message = "Profile updated, confirm the new email address with the link sent to it"
+	}
+	rb.Success(http.StatusOK, profileResponse(&user), message)
+}
+
+// emailChangeEmail builds the message that asks to confirm a new address
+func emailChangeEmail(c *gin.Context, cfg *config.Config, user *models.User, newEmail string, token string) *email.Message {
+	return &email.Message{
+		To:       newEmail,
+		Template: "confirm_email_change",
+		Locale:   requestLocale(c),
+		Data: map[string]interface{}{
+			"Username": user.Username,
+			"Token":    token,
+			"Link":     verificationLink(cfg, token),
+			"Hours":    int(cfg.Security.EmailVerification.TokenTTL.Hours()),
+		},
+	}
+}
+
+// verificationLink is the page that redeems a verification token, empty when
+// only the token is sent
+func verificationLink(cfg *config.Config, token string) string {
+	linkURL := cfg.Security.EmailVerification.LinkURL
+	if linkURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s?token=%s", linkURL, url.QueryEscape(token))
+}
+
+// profileResponse is the profile of a user as returned by the API
+func profileResponse(user *models.User) dto.UserProfileResponse {
+	return dto.UserProfileResponse{
+		UserID:        user.UserID,
+		Username:      user.Username,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Phone:         user.Phone,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+}
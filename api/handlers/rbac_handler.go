@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RBACHandler serves the admin endpoints for roles, permissions and role
+// assignments. Changes reach access tokens when they are next refreshed.
+type RBACHandler struct {
+	DB     *gorm.DB
+	Cfg    *config.Config
+	logger *log.Logger
+	rbac   *utils.RBACStore
+}
+
+func NewRBACHandler(db *gorm.DB, cfg *config.Config) *RBACHandler {
+	return &RBACHandler{
+		DB:     db,
+		Cfg:    cfg,
+		logger: log.New(log.Writer(), "RBACHandler: ", log.LstdFlags),
+		rbac:   utils.NewRBACStore(db),
+	}
+}
+
+func (h *RBACHandler) ListRoles(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	roles, err := h.rbac.ListRoles(c.Request.Context())
+	if err != nil {
+		h.logger.Printf("Failed to list roles: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch roles")
+		return
+	}
+
+	response := make([]dto.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, roleResponse(&role.Role, role.Permissions))
+	}
+
+	rb.Success(http.StatusOK, response, "Roles retrieved successfully")
+}
+
+func (h *RBACHandler) CreateRole(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	var req dto.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	role, err := h.rbac.CreateRole(c.Request.Context(), req.Name, req.Description)
+	if err != nil {
+		if errors.Is(err, utils.ErrRoleExists) {
+			rb.Error(http.StatusConflict, "Role already exists")
+			return
+		}
+		h.logger.Printf("Failed to create role: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to create role")
+		return
+	}
+
+	rb.Success(http.StatusCreated, roleResponse(role, nil), "Role created successfully")
+}
+
+func (h *RBACHandler) DeleteRole(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid role ID")
+		return
+	}
+
+	if err := h.rbac.DeleteRole(c.Request.Context(), roleID); err != nil {
+		h.rbacError(rb, err, "Failed to delete role")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Role deleted successfully")
+}
+
+func (h *RBACHandler) GrantPermission(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid role ID")
+		return
+	}
+
+	var req dto.GrantPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	if err := h.rbac.GrantPermission(c.Request.Context(), roleID, req.PermissionID); err != nil {
+		h.rbacError(rb, err, "Failed to grant permission")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Permission granted successfully")
+}
+
+func (h *RBACHandler) RevokePermission(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid role ID")
+		return
+	}
+	permissionID, err := uuid.Parse(c.Param("permissionId"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid permission ID")
+		return
+	}
+
+	if err := h.rbac.RevokePermission(c.Request.Context(), roleID, permissionID); err != nil {
+		h.rbacError(rb, err, "Failed to revoke permission")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Permission revoked successfully")
+}
+
+func (h *RBACHandler) ListPermissions(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	permissions, err := h.rbac.ListPermissions(c.Request.Context())
+	if err != nil {
+		h.logger.Printf("Failed to list permissions: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch permissions")
+		return
+	}
+
+	response := make([]dto.PermissionResponse, 0, len(permissions))
+	for i := range permissions {
+		response = append(response, permissionResponse(&permissions[i]))
+	}
+
+	rb.Success(http.StatusOK, response, "Permissions retrieved successfully")
+}
+
+func (h *RBACHandler) CreatePermission(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	var req dto.CreatePermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	permission, err := h.rbac.CreatePermission(c.Request.Context(), req.Name, req.Description)
+	if err != nil {
+		if errors.Is(err, utils.ErrPermissionExists) {
+			rb.Error(http.StatusConflict, "Permission already exists")
+			return
+		}
+		h.logger.Printf("Failed to create permission: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to create permission")
+		return
+	}
+
+	rb.Success(http.StatusCreated, permissionResponse(permission), "Permission created successfully")
+}
+
+func (h *RBACHandler) DeletePermission(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	permissionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid permission ID")
+		return
+	}
+
+	if err := h.rbac.DeletePermission(c.Request.Context(), permissionID); err != nil {
+		h.rbacError(rb, err, "Failed to delete permission")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Permission deleted successfully")
+}
+
+func (h *RBACHandler) ListUserRoles(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	roles, err := h.rbac.UserRoles(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list user roles: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch user roles")
+		return
+	}
+
+	response := make([]dto.RoleResponse, 0, len(roles))
+	for i := range roles {
+		response = append(response, roleResponse(&roles[i], nil))
+	}
+
+	rb.Success(http.StatusOK, response, "User roles retrieved successfully")
+}
+
+func (h *RBACHandler) AssignRole(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req dto.AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	if err := h.rbac.AssignRole(c.Request.Context(), userID, req.RoleID); err != nil {
+		h.rbacError(rb, err, "Failed to assign role")
+		return
+	}
+
+	h.logger.Printf("User %s assigned role %s to user %s", c.GetString("userID"), req.RoleID, userID)
+	rb.Success(http.StatusOK, nil, "Role assigned successfully")
+}
+
+func (h *RBACHandler) UnassignRole(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	roleID, err := uuid.Parse(c.Param("roleId"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid role ID")
+		return
+	}
+
+	if err := h.rbac.UnassignRole(c.Request.Context(), userID, roleID); err != nil {
+		h.rbacError(rb, err, "Failed to unassign role")
+		return
+	}
+
+	h.logger.Printf("User %s removed role %s from user %s", c.GetString("userID"), roleID, userID)
+	rb.Success(http.StatusOK, nil, "Role unassigned successfully")
+}
+
+// rbacError maps the store errors to responses
+func (h *RBACHandler) rbacError(rb *dto.ResponseBuilder, err error, message string) {
+	switch {
+	case errors.Is(err, utils.ErrRoleNotFound):
+		rb.Error(http.StatusNotFound, "Role not found")
+	case errors.Is(err, utils.ErrPermissionNotFound):
+		rb.Error(http.StatusNotFound, "Permission not found")
+	case errors.Is(err, utils.ErrUserNotFound):
+		rb.Error(http.StatusNotFound, "User not found")
+	case errors.Is(err, utils.ErrRoleProtected):
+		rb.Error(http.StatusConflict, "Default roles cannot be deleted")
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		rb.Error(http.StatusInternalServerError, message)
+	}
+}
+
+func roleResponse(role *models.Role, permissions []string) dto.RoleResponse {
+	return dto.RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+		CreatedAt:   role.CreatedAt,
+	}
+}
+
+func permissionResponse(permission *models.Permission) dto.PermissionResponse {
+	return dto.PermissionResponse{
+		ID:          permission.ID,
+		Name:        permission.Name,
+		Description: permission.Description,
+		CreatedAt:   permission.CreatedAt,
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// secondFactorVerifier checks the second factor of a signed in user confirming
+// a sensitive action. It is shared by the handlers serving such actions.
+type secondFactorVerifier struct {
+	cfg      *config.Config
+	logger   *log.Logger
+	mfa      *utils.MFAStore
+	webauthn *utils.WebAuthnStore
+	outbox   *utils.OutboxStore
+}
+
+func newSecondFactorVerifier(db *gorm.DB, cfg *config.Config, logger *log.Logger, mfa *utils.MFAStore) *secondFactorVerifier {
+	return &secondFactorVerifier{
+		cfg:      cfg,
+		logger:   logger,
+		mfa:      mfa,
+		webauthn: utils.NewWebAuthnStore(db, &cfg.WebAuthn),
+		outbox:   utils.NewOutboxStore(db),
+	}
+}
+
+// verify passes users without a second factor. The others answer with any of
+// the factors they set up, the same ones a login accepts, or a recovery code.
+// The error response is written here.
+func (v *secondFactorVerifier) verify(c *gin.Context, rb *dto.ResponseBuilder, user *models.User, answer *dto.SecondFactorAnswer) bool {
+	methods, err := mfaMethods(c, v.cfg, v.mfa, v.webauthn, user.UserID)
+	if err != nil {
+		v.logger.Printf("Failed to check MFA enrollment: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to verify the second factor")
+		return false
+	}
+	if len(methods) == 0 {
+		return true
+	}
+
+	if answer.WebAuthnCredential != nil {
+		if !hasMFAMethod(methods, mfaMethodWebAuthn) {
+			rb.Error(http.StatusBadRequest, "No passkey is registered")
+			return false
+		}
+		return v.verifyPasskey(c, rb, user, answer)
+	}
+	if answer.Code == "" && answer.RecoveryCode == "" {
+		rb.Error(http.StatusForbidden, "A second factor is required, answer with an authentication code, a passkey or a recovery code")
+		return false
+	}
+
+	usedRecovery, err := v.mfa.Verify(c.Request.Context(), user.UserID, answer.Code, answer.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrMFANotEnrolled):
+			rb.Error(http.StatusBadRequest, "No authenticator is enrolled, answer with a passkey instead")
+		case errors.Is(err, utils.ErrMFAInvalidCode):
+			rb.Error(http.StatusBadRequest, "Invalid authentication code")
+		case errors.Is(err, utils.ErrMFALocked):
+			rb.Error(http.StatusTooManyRequests, "Too many failed attempts, try again later")
+		default:
+			v.logger.Printf("Failed to verify code: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to verify authentication code")
+		}
+		return false
+	}
+	if usedRecovery {
+		notifyRecoveryCodeUsed(c, v.outbox, v.mfa, v.logger, user)
+	}
+	return true
+}
+
+// verifyPasskey finishes the assertion begun for the user by
+// WebAuthnHandler.VerifyBegin
+func (v *secondFactorVerifier) verifyPasskey(c *gin.Context, rb *dto.ResponseBuilder, user *models.User, answer *dto.SecondFactorAnswer) bool {
+	credential, err := v.webauthn.FinishAssertion(c.Request.Context(), answer.WebAuthnSessionID, utils.CeremonySecondFactor, assertionResponse(answer.WebAuthnCredential))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrWebAuthnSessionInvalid):
+			rb.Error(http.StatusBadRequest, "Passkey verification is invalid or expired, start a new one")
+		case errors.Is(err, utils.ErrWebAuthnVerification), errors.Is(err, utils.ErrCredentialNotFound):
+			v.logger.Printf("Failed passkey assertion: %v", err)
+			rb.Error(http.StatusForbidden, "Passkey could not be verified")
+		default:
+			v.logger.Printf("Failed to verify passkey: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to verify the second factor")
+		}
+		return false
+	}
+	if credential.UserID != user.UserID {
+		v.logger.Printf("Passkey of user %s answered the second factor of user %s", credential.UserID, user.UserID)
+		rb.Error(http.StatusForbidden, "Passkey could not be verified")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	DB          *gorm.DB
+	Cfg         *config.Config
+	logger      *log.Logger
+	tokenStore  *utils.TokenStore
+	revocations utils.RevocationStore
+}
+
+func NewSessionHandler(db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) *SessionHandler {
+	return &SessionHandler{
+		DB:          db,
+		Cfg:         cfg,
+		logger:      log.New(log.Writer(), "SessionHandler: ", log.LstdFlags),
+		tokenStore:  utils.NewTokenStore(db),
+		revocations: revocations,
+	}
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sessions, err := h.tokenStore.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list sessions: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	currentSession := c.GetString("sessionID")
+	response := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, dto.SessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Current:    session.ID.String() == currentSession,
+		})
+	}
+
+	rb.Success(http.StatusOK, response, "Sessions retrieved successfully")
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	if err := h.tokenStore.RevokeSession(c.Request.Context(), userID, sessionID); err != nil {
+		if errors.Is(err, utils.ErrSessionNotFound) {
+			rb.Error(http.StatusNotFound, "Session not found")
+			return
+		}
+		h.logger.Printf("Failed to revoke session: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if err := revokeSessionTokens(c, h.Cfg, h.revocations, sessionID); err != nil {
+		h.logger.Printf("Failed to revoke access tokens of session %s: %v", sessionID, err)
+		rb.Error(http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	rb.Success(http.StatusOK, nil, "Session revoked successfully")
+}
+
+// RevokeOtherSessions logs the user out everywhere except the current session
+func (h *SessionHandler) RevokeOtherSessions(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.GetString("sessionID"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Current token is not bound to a session")
+		return
+	}
+
+	revoked, err := h.tokenStore.RevokeOtherSessions(c.Request.Context(), userID, sessionID)
+	if err != nil {
+		h.logger.Printf("Failed to revoke other sessions: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	for _, id := range revoked {
+		if err := revokeSessionTokens(c, h.Cfg, h.revocations, id); err != nil {
+			h.logger.Printf("Failed to revoke access tokens of session %s: %v", id, err)
+			rb.Error(http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+	}
+
+	rb.Success(http.StatusOK, gin.H{"revoked": len(revoked)}, "Other sessions revoked successfully")
+}
+
+// revokeSessionTokens stops the access tokens of a revoked session from being
+// accepted for the rest of their lifetime
+func revokeSessionTokens(c *gin.Context, cfg *config.Config, revocations utils.RevocationStore, sessionID uuid.UUID) error {
+	return utils.RevokeSessionTokens(c.Request.Context(), revocations, sessionID, time.Now().Add(cfg.JWT.AccessTokenExpiry))
+}
+
+// currentUserID reads the authenticated user ID set by the auth middleware
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return userID, true
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	errRefreshTokenRejected = errors.New("invalid or expired refresh token")
+	errNotOrgMember         = errors.New("user is not a member of the organization")
+)
+
+// tokenRefresher exchanges refresh tokens for new token pairs. It is shared by
+// the first-party refresh endpoint and the OAuth token endpoint.
+type tokenRefresher struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	logger      *log.Logger
+	tokenStore  *utils.TokenStore
+	revocations utils.RevocationStore
+	rbac        *utils.RBACStore
+	orgs        *utils.OrganizationStore
+}
+
+// refresh validates and rotates the refresh token. Tokens must have been issued
+// to clientID, which is empty for first-party logins. A non-nil orgID switches
+// the organization context, otherwise the current one is kept while the user
+// is still a member. Every rejection the caller should report as 401 is
+// returned as errRefreshTokenRejected.
+func (r *tokenRefresher) refresh(c *gin.Context, refreshToken string, clientID string, orgID *uuid.UUID) (*utils.TokenDetails, *utils.Claims, error) {
+	ctx := c.Request.Context()
+
+	// Validate refresh token signature and type
+	claims, err := utils.ValidateRefreshToken(refreshToken, &r.cfg.JWT)
+	if err != nil || claims.ClientID != clientID {
+		return nil, nil, errRefreshTokenRejected
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil, errRefreshTokenRejected
+	}
+
+	// Tokens issued before a user-wide revocation cannot be refreshed
+	revoked, err := utils.TokenRevoked(ctx, r.revocations, claims)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, nil, errRefreshTokenRejected
+	}
+
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, errRefreshTokenRejected
+		}
+		return nil, nil, fmt.Errorf("failed to fetch user for token refresh: %w", err)
+	}
+
+	// First-party tokens follow the user's email verification, so verifying
+	// lifts the scope limit on the next refresh. Client tokens keep the scope
+	// the user consented to, narrowed while the email is unverified.
+	limit, allowed := utils.UnverifiedLoginScope(r.cfg, &user)
+	if !allowed {
+		return nil, nil, errRefreshTokenRejected
+	}
+	scope := limit
+	if claims.ClientID != "" {
+		scope = claims.Scope
+		if limit != "" {
+			scope = utils.LimitScope(scope, limit)
+		}
+	}
+
+	// Role changes take effect on the next refresh. Client tokens act within
+	// their scope and carry no roles.
+	var roles, permissions []string
+	if claims.ClientID == "" {
+		roles, permissions, err = r.rbac.UserAccess(ctx, user.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Resolve the organization context and the user's current role in it
+	var orgRole string
+	if orgID == nil && claims.OrgID != "" {
+		if current, err := uuid.Parse(claims.OrgID); err == nil {
+			orgID = &current
+		}
+	}
+	if orgID != nil {
+		membership, err := r.orgs.GetMembership(ctx, *orgID, user.UserID)
+		switch {
+		case err == nil:
+			orgRole = membership.Role
+		case !errors.Is(err, utils.ErrMembershipNotFound):
+			return nil, nil, err
+		case claims.OrgID == orgID.String():
+			// Removed from the organization, fall back to no org context
+			orgID = nil
+		default:
+			return nil, nil, errNotOrgMember
+		}
+	}
+	var org string
+	if orgID != nil {
+		org = orgID.String()
+	}
+
+	// Generate the replacement token pair
+	tokens, err := utils.GenerateTokenPair(utils.TokenOptions{
+		UserID:      user.UserID.String(),
+		Username:    user.Username,
+		SessionID:   claims.SessionID,
+		ClientID:    claims.ClientID,
+		Scope:       scope,
+		Roles:       roles,
+		Permissions: permissions,
+		OrgID:       org,
+		OrgRole:     orgRole,
+	}, &r.cfg.JWT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate tokens: %w", err)
+	}
+
+	// Rotate the stored refresh token
+	if err := r.tokenStore.RotateToken(ctx, claims.ID, user.UserID, tokens); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRefreshTokenReused):
+			r.logger.Printf("Refresh token reuse detected for user %s from %s, token family revoked (suspected theft)", user.UserID, c.ClientIP())
+			if sessionID, err := uuid.Parse(claims.SessionID); err == nil {
+				if err := revokeSessionTokens(c, r.cfg, r.revocations, sessionID); err != nil {
+					r.logger.Printf("Failed to revoke access tokens of session %s: %v", sessionID, err)
+				}
+			}
+			return nil, nil, errRefreshTokenRejected
+		case errors.Is(err, utils.ErrRefreshTokenNotFound):
+			return nil, nil, errRefreshTokenRejected
+		default:
+			return nil, nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+	}
+
+	return tokens, claims, nil
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebAuthnHandler serves passkey registration and management for the signed in
+// user. Logins with a passkey are served by AuthHandler.
+type WebAuthnHandler struct {
+	DB       *gorm.DB
+	Cfg      *config.Config
+	logger   *log.Logger
+	webauthn *utils.WebAuthnStore
+	mfa      *utils.MFAStore
+}
+
+func NewWebAuthnHandler(db *gorm.DB, cfg *config.Config) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		DB:       db,
+		Cfg:      cfg,
+		logger:   log.New(log.Writer(), "WebAuthnHandler: ", log.LstdFlags),
+		webauthn: utils.NewWebAuthnStore(db, &cfg.WebAuthn),
+		mfa:      utils.NewMFAStore(db, &cfg.Security),
+	}
+}
+
+// RegisterBegin returns the options for navigator.credentials.create()
+func (h *WebAuthnHandler) RegisterBegin(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var user models.User
+	if err := h.DB.WithContext(c.Request.Context()).First(&user, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rb.Error(http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.Printf("Failed to fetch user: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start registration")
+		return
+	}
+
+	// Registering the same authenticator twice is refused by the browser
+	existing, err := h.webauthn.ListCredentials(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list credentials: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start registration")
+		return
+	}
+
+	session, err := h.webauthn.BeginCeremony(c.Request.Context(), utils.CeremonyRegistration, &userID)
+	if err != nil {
+		h.logger.Printf("Failed to start registration: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start registration")
+		return
+	}
+
+	params := make([]dto.CredentialParameter, 0, len(utils.SupportedCOSEAlgorithms))
+	for _, alg := range utils.SupportedCOSEAlgorithms {
+		params = append(params, dto.CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	rpName := h.Cfg.WebAuthn.RPName
+	if rpName == "" {
+		rpName = h.Cfg.App.Name
+	}
+
+	rb.Success(http.StatusOK, dto.WebAuthnRegistrationOptionsResponse{
+		SessionID: session.ID,
+		PublicKey: dto.CredentialCreationOptions{
+			RP: dto.RelyingPartyEntity{ID: h.Cfg.WebAuthn.RPID, Name: rpName},
+			User: dto.UserEntity{
+				ID:          dto.Base64URL(userID[:]),
+				Name:        user.Email,
+				DisplayName: user.Username,
+			},
+			Challenge:          session.Challenge,
+			PubKeyCredParams:   params,
+			Timeout:            h.Cfg.WebAuthn.ChallengeTTL.Milliseconds(),
+			ExcludeCredentials: credentialDescriptors(existing),
+			AuthenticatorSelection: dto.AuthenticatorSelection{
+				ResidentKey:      "preferred",
+				UserVerification: "preferred",
+			},
+			Attestation: "none",
+		},
+	}, "Registration started")
+}
+
+// RegisterFinish verifies the authenticator's response and stores the passkey
+func (h *WebAuthnHandler) RegisterFinish(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req dto.WebAuthnRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rb.ValidationError(http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	credential, err := h.webauthn.FinishRegistration(c.Request.Context(), req.SessionID, userID, req.Name, &utils.RegistrationResponse{
+		ClientDataJSON:    req.Credential.Response.ClientDataJSON,
+		AttestationObject: req.Credential.Response.AttestationObject,
+		Transports:        req.Credential.Response.Transports,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrWebAuthnSessionInvalid):
+			rb.Error(http.StatusBadRequest, "Registration is invalid or expired, start a new one")
+		case errors.Is(err, utils.ErrWebAuthnVerification):
+			h.logger.Printf("Rejected passkey registration for user %s: %v", userID, err)
+			rb.Error(http.StatusBadRequest, "Passkey could not be verified")
+		case errors.Is(err, utils.ErrCredentialExists):
+			rb.Error(http.StatusConflict, "Passkey is already registered")
+		default:
+			h.logger.Printf("Failed to register passkey: %v", err)
+			rb.Error(http.StatusInternalServerError, "Failed to register passkey")
+		}
+		return
+	}
+
+	h.logger.Printf("User %s registered passkey %s", userID, credential.ID)
+
+	// Passkeys are a second factor, so a user without recovery codes gets them
+	// now in case every passkey is lost
+	response := dto.RegisteredCredentialResponse{WebAuthnCredentialResponse: credentialResponse(credential)}
+	if h.Cfg.Features.EnableMFA {
+		remaining, err := h.mfa.RemainingRecoveryCodes(c.Request.Context(), userID)
+		if err != nil {
+			h.logger.Printf("Failed to count recovery codes: %v", err)
+		} else if remaining == 0 {
+			codes, err := h.mfa.GenerateRecoveryCodes(c.Request.Context(), userID)
+			if err != nil {
+				h.logger.Printf("Failed to generate recovery codes: %v", err)
+				rb.Error(http.StatusInternalServerError, "Passkey registered but recovery codes could not be generated, regenerate them")
+				return
+			}
+			response.RecoveryCodes = codes
+			rb.Success(http.StatusCreated, response, "Passkey registered successfully, store the recovery codes now as they cannot be shown again")
+			return
+		}
+	}
+
+	rb.Success(http.StatusCreated, response, "Passkey registered successfully")
+}
+
+// ListCredentials returns the user's passkeys
+func (h *WebAuthnHandler) ListCredentials(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	credentials, err := h.webauthn.ListCredentials(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list credentials: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to list passkeys")
+		return
+	}
+
+	response := make([]dto.WebAuthnCredentialResponse, 0, len(credentials))
+	for i := range credentials {
+		response = append(response, credentialResponse(&credentials[i]))
+	}
+	rb.Success(http.StatusOK, response, "Passkeys retrieved successfully")
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		rb.Error(http.StatusBadRequest, "Invalid passkey ID")
+		return
+	}
+
+	if err := h.webauthn.DeleteCredential(c.Request.Context(), userID, id); err != nil {
+		if errors.Is(err, utils.ErrCredentialNotFound) {
+			rb.Error(http.StatusNotFound, "Passkey not found")
+			return
+		}
+		h.logger.Printf("Failed to delete passkey: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to delete passkey")
+		return
+	}
+
+	h.logger.Printf("User %s deleted passkey %s", userID, id)
+	rb.Success(http.StatusOK, nil, "Passkey deleted successfully")
+}
+
+// VerifyBegin returns the options for navigator.credentials.get() confirming a
+// sensitive action of the signed in user, such as deleting the account or
+// turning off an authenticator
+func (h *WebAuthnHandler) VerifyBegin(c *gin.Context) {
+	rb := dto.NewResponse(c)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		rb.Error(http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	credentials, err := h.webauthn.ListCredentials(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Failed to list credentials: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start passkey verification")
+		return
+	}
+	if len(credentials) == 0 {
+		rb.Error(http.StatusBadRequest, "No passkey is registered")
+		return
+	}
+
+	session, err := h.webauthn.BeginCeremony(c.Request.Context(), utils.CeremonySecondFactor, &userID)
+	if err != nil {
+		h.logger.Printf("Failed to start passkey verification: %v", err)
+		rb.Error(http.StatusInternalServerError, "Failed to start passkey verification")
+		return
+	}
+
+	rb.Success(http.StatusOK, requestOptions(h.Cfg, session, credentials, "preferred"), "Passkey verification started")
+}
+
+// requestOptions builds the options for navigator.credentials.get()
+func requestOptions(cfg *config.Config, session *models.WebAuthnSession, allowed []models.WebAuthnCredential, userVerification string) dto.WebAuthnLoginOptionsResponse {
+	return dto.WebAuthnLoginOptionsResponse{
+		SessionID: session.ID,
+		PublicKey: dto.CredentialRequestOptions{
+			Challenge:        session.Challenge,
+			Timeout:          cfg.WebAuthn.ChallengeTTL.Milliseconds(),
+			RPID:             cfg.WebAuthn.RPID,
+			AllowCredentials: credentialDescriptors(allowed),
+			UserVerification: userVerification,
+		},
+	}
+}
+
+func credentialDescriptors(credentials []models.WebAuthnCredential) []dto.CredentialDescriptor {
+	descriptors := make([]dto.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, dto.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
+
+func assertionResponse(credential *dto.AssertionCredential) *utils.AssertionResponse {
+	return &utils.AssertionResponse{
+		CredentialID:      credential.RawID,
+		ClientDataJSON:    credential.Response.ClientDataJSON,
+		AuthenticatorData: credential.Response.AuthenticatorData,
+		Signature:         credential.Response.Signature,
+		UserHandle:        credential.Response.UserHandle,
+	}
+}
+
+func credentialResponse(credential *models.WebAuthnCredential) dto.WebAuthnCredentialResponse {
+	return dto.WebAuthnCredentialResponse{
+		ID:                credential.ID,
+		Name:              credential.Name,
+		Transports:        credential.Transports,
+		AttestationFormat: credential.AttestationFormat,
+		BackedUp:          credential.BackedUp,
+		LastUsedAt:        credential.LastUsedAt,
+		CreatedAt:         credential.CreatedAt,
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type WellKnownHandler struct {
+	Cfg    *config.Config
+	logger *log.Logger
+}
+
+func NewWellKnownHandler(cfg *config.Config) *WellKnownHandler {
+	return &WellKnownHandler{
+		Cfg:    cfg,
+		logger: log.New(log.Writer(), "WellKnownHandler: ", log.LstdFlags),
+	}
+}
+
+// JWKS publishes the public keys used to verify access tokens. The document is
+// returned bare, as JWKS clients expect, rather than in a StandardResponse.
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	set, err := utils.PublicJWKS()
+	if err != nil {
+		h.logger.Printf("Failed to build JWKS: %v", err)
+		dto.NewResponse(c).Error(http.StatusInternalServerError, "Failed to load signing keys")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
+
+// OpenIDConfiguration publishes the OpenID Connect discovery document
+// (OpenID Connect Discovery 1.0 section 3)
+func (h *WellKnownHandler) OpenIDConfiguration(c *gin.Context) {
+	base := publicURL(c, h.Cfg)
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, dto.OpenIDConfiguration{
+		Issuer:                            h.Cfg.JWT.Issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserInfoEndpoint:                  base + "/oauth/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		RevocationEndpoint:                base + "/oauth/revoke",
+		ScopesSupported:                   []string{utils.ScopeOpenID, utils.ScopeProfile, utils.ScopeEmail, utils.ScopePhone},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{utils.GrantAuthorizationCode, utils.GrantRefreshToken, utils.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{h.Cfg.JWT.Algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{utils.PKCEMethodS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+			"name", "given_name", "family_name", "preferred_username", "email", "phone_number",
+		},
+	})
+}
+
+// publicURL returns the configured public base URL, or the one the request
+// was made to
+func publicURL(c *gin.Context, cfg *config.Config) string {
+	if cfg.OAuth.PublicURL != "" {
+		return strings.TrimSuffix(cfg.OAuth.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := c.GetHeader("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -1,6 +1,8 @@
 package middlewares
 
 import (
+    "errors"
+    "log"
     "net/http"
     "strings"
     "github.com/gin-gonic/gin"
@@ -9,15 +11,16 @@ import (
     "github.com/HersheyPlus/go-auth/config"
 )
 
-// AuthMiddleware verifies JWT tokens in the Authorization header
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// AuthMiddleware verifies JWT tokens and personal access tokens in the
+// Authorization header and rejects tokens found in the revocation store
+func AuthMiddleware(cfg *config.Config, revocations utils.RevocationStore, personalTokens *utils.PersonalAccessTokenStore) gin.HandlerFunc {
     return func(c *gin.Context) {
         rb := dto.NewResponse(c)
 
         // Get token from Authorization header
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
-            rb.Error(http.StatusUnauthorized, "Authorization header is required")
+            rb.ErrorWithCode(http.StatusUnauthorized, dto.CodeTokenMissing, "Authorization header is required")
             c.Abort()
             return
         }
@@ -25,24 +28,120 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
         // Check Bearer scheme
         tokenParts := strings.Split(authHeader, " ")
         if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
-            rb.Error(http.StatusUnauthorized, "Invalid authorization header format")
+            rb.ErrorWithCode(http.StatusUnauthorized, dto.CodeTokenMalformed, "Invalid authorization header format")
             c.Abort()
             return
         }
 
+        // Personal access tokens are opaque and looked up by hash
+        if utils.IsPersonalAccessToken(tokenParts[1]) {
+            authenticatePersonalToken(c, rb, tokenParts[1], revocations, personalTokens)
+            return
+        }
+
         // Validate token
-        claims, err := utils.ValidateToken(tokenParts[1], cfg.JWT.SecretKey)
+        claims, err := utils.ValidateAccessToken(tokenParts[1], &cfg.JWT)
         if err != nil {
-            rb.Error(http.StatusUnauthorized, "Invalid or expired token")
+            code, message := tokenErrorCode(err)
+            rb.ErrorWithCode(http.StatusUnauthorized, code, message)
             c.Abort()
             return
         }
 
-        // Store user info in context
-        c.Set("userID", claims.Subject)
-        c.Set("username", claims.Username)
+        // Check revocation
+        revoked, err := utils.TokenRevoked(c.Request.Context(), revocations, claims)
+        if err != nil {
+            log.Printf("Failed to check token revocation: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to verify token")
+            c.Abort()
+            return
+        }
+        if revoked {
+            rb.ErrorWithCode(http.StatusUnauthorized, dto.CodeTokenRevoked, "Token has been revoked")
+            c.Abort()
+            return
+        }
+
+        // Store principal info in context. Service tokens have no user.
+        c.Set("authMethod", "jwt")
+        c.Set("principalType", claims.Principal())
+        c.Set("clientID", claims.ClientID)
+        c.Set("scope", claims.Scope)
+        if claims.Principal() == utils.PrincipalUser {
+            c.Set("userID", claims.Subject)
+            c.Set("username", claims.Username)
+            c.Set("sessionID", claims.SessionID)
+            c.Set("roles", claims.Roles)
+            c.Set("permissions", claims.Permissions)
+            c.Set("orgID", claims.OrgID)
+            c.Set("orgRole", claims.OrgRole)
+        }
         c.Next()
     }
 }
 
+// authenticatePersonalToken authenticates a personal access token. Tokens
+// created before a user-wide revocation are rejected like JWTs issued before it.
+func authenticatePersonalToken(c *gin.Context, rb *dto.ResponseBuilder, plain string, revocations utils.RevocationStore, personalTokens *utils.PersonalAccessTokenStore) {
+    ctx := c.Request.Context()
+    token, err := personalTokens.Authenticate(ctx, plain)
+    if err != nil {
+        if errors.Is(err, utils.ErrPersonalAccessTokenInvalid) {
+            rb.ErrorWithCode(http.StatusUnauthorized, dto.CodeTokenInvalid, "Invalid or expired token")
+        } else {
+            log.Printf("Failed to authenticate personal access token: %v", err)
+            rb.Error(http.StatusInternalServerError, "Failed to verify token")
+        }
+        c.Abort()
+        return
+    }
 
+    cutoff, err := revocations.UserTokensRevokedBefore(ctx, token.UserID)
+    if err != nil {
+        log.Printf("Failed to check token revocation: %v", err)
+        rb.Error(http.StatusInternalServerError, "Failed to verify token")
+        c.Abort()
+        return
+    }
+    if !cutoff.IsZero() && token.CreatedAt.Before(cutoff) {
+        rb.ErrorWithCode(http.StatusUnauthorized, dto.CodeTokenRevoked, "Token has been revoked")
+        c.Abort()
+        return
+    }
+
+    c.Set("authMethod", "personal_access_token")
+    c.Set("principalType", utils.PrincipalUser)
+    c.Set("userID", token.UserID.String())
+    c.Set("personalTokenID", token.ID.String())
+    c.Set("scope", strings.Join(token.Scopes, " "))
+    c.Next()
+}
+
+// RequireUser rejects service principals on routes that act on a user account.
+// It must run after AuthMiddleware.
+func RequireUser() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("principalType") != utils.PrincipalUser {
+            dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeTokenWrongPrincipal, "This endpoint requires a user token")
+            c.Abort()
+            return
+        }
+        c.Next()
+    }
+}
+
+// tokenErrorCode maps a token validation error to an error code and message
+func tokenErrorCode(err error) (string, string) {
+    switch {
+    case errors.Is(err, utils.ErrTokenExpired):
+        return dto.CodeTokenExpired, "Token has expired"
+    case errors.Is(err, utils.ErrInvalidIssuer):
+        return dto.CodeTokenWrongIssuer, "Token was issued by an unknown issuer"
+    case errors.Is(err, utils.ErrInvalidAudience):
+        return dto.CodeTokenWrongAudience, "Token is not intended for this service"
+    case errors.Is(err, utils.ErrInvalidTokenType):
+        return dto.CodeTokenWrongType, "Token type is not accepted here"
+    default:
+        return dto.CodeTokenInvalid, "Invalid or expired token"
+    }
+}
@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/dto"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScopes rejects tokens that were not granted every listed scope. It
+// must run after AuthMiddleware. Tokens from a first-party login carry no
+// scope and act with the full rights of the user, so only delegated tokens
+// (OAuth clients, service accounts and personal access tokens) are restricted.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !scopeRestricted(c) {
+			c.Next()
+			return
+		}
+
+		granted := c.GetString("scope")
+		for _, scope := range scopes {
+			if !utils.HasScope(granted, scope) {
+				required := strings.Join(scopes, " ")
+				c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+required+`"`)
+				dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientScope, "Token is missing the required scope: "+required)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireInteractiveSession rejects personal access tokens and tokens issued
+// to OAuth clients on routes that manage credentials or the account itself, so
+// that a leaked or delegated token cannot take the account over. It must run
+// after AuthMiddleware.
+func RequireInteractiveSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsInteractiveSession(c) {
+			dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInteractiveRequired, "This action requires signing in to the application")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// IsInteractiveSession reports whether the request was made with the token of
+// a first-party login, rather than a personal access token or a token issued
+// to an OAuth client
+func IsInteractiveSession(c *gin.Context) bool {
+	return c.GetString("authMethod") == "jwt" &&
+		c.GetString("principalType") == utils.PrincipalUser &&
+		c.GetString("clientID") == ""
+}
+
+// RequireAnyRole rejects principals holding none of the listed roles. Roles
+// only apply to first-party logins, delegated tokens are always rejected. It
+// must run after AuthMiddleware.
+func RequireAnyRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopeRestricted(c) {
+			dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientRole, "This action requires a first-party login")
+			c.Abort()
+			return
+		}
+
+		held := c.GetStringSlice("roles")
+		for _, role := range roles {
+			for _, h := range held {
+				if h == role {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientRole, "This action requires one of the roles: "+strings.Join(roles, ", "))
+		c.Abort()
+	}
+}
+
+// RequirePermissions rejects principals missing any of the listed
+// permissions. Like roles, permissions only apply to first-party logins. It
+// must run after AuthMiddleware.
+func RequirePermissions(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopeRestricted(c) {
+			dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientPermission, "This action requires a first-party login")
+			c.Abort()
+			return
+		}
+
+		held := make(map[string]bool)
+		for _, permission := range c.GetStringSlice("permissions") {
+			held[permission] = true
+		}
+		for _, permission := range permissions {
+			if !held[permission] {
+				dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientPermission, "This action requires the permission: "+permission)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireOrg rejects requests for an organization other than the one the
+// token was switched to. The organization is taken from the :id path
+// parameter. It must run after AuthMiddleware.
+func RequireOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := c.GetString("orgID")
+		if orgID == "" || orgID != c.Param("id") {
+			dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeWrongOrganization, "Token is not scoped to this organization, switch to it first")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOrgRole rejects members holding none of the listed roles in the
+// active organization. It must run after RequireOrg.
+func RequireOrgRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := c.GetString("orgRole")
+		for _, role := range roles {
+			if held == role {
+				c.Next()
+				return
+			}
+		}
+
+		dto.NewResponse(c).ErrorWithCode(http.StatusForbidden, dto.CodeInsufficientOrgRole, "This action requires one of the organization roles: "+strings.Join(roles, ", "))
+		c.Abort()
+	}
+}
+
+// scopeRestricted reports whether the request was made with a delegated token
+// or a first-party token limited to a scope
+func scopeRestricted(c *gin.Context) bool {
+	return c.GetString("authMethod") != "jwt" ||
+		c.GetString("principalType") != utils.PrincipalUser ||
+		c.GetString("clientID") != "" ||
+		c.GetString("scope") != ""
+}
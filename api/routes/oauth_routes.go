@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/HersheyPlus/go-auth/api/handlers"
+	"github.com/HersheyPlus/go-auth/api/middlewares"
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func OAuthRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore) {
+	oauthHandler := handlers.NewOAuthHandler(db, cfg, revocations)
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", oauthHandler.Authorize)
+		oauth.POST("/authorize", oauthHandler.AuthorizeDecision)
+		oauth.POST("/token", oauthHandler.Token)
+		oauth.POST("/introspect", oauthHandler.Introspect)
+		oauth.POST("/revoke", oauthHandler.Revoke)
+
+		requireUser := []gin.HandlerFunc{middlewares.AuthMiddleware(cfg, revocations, utils.NewPersonalAccessTokenStore(db)), middlewares.RequireUser(), oauthHandler.UserInfo}
+		oauth.GET("/userinfo", requireUser...)
+		oauth.POST("/userinfo", requireUser...)
+	}
+}
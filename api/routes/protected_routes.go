@@ -6,14 +6,95 @@ import (
 	"github.com/HersheyPlus/go-auth/config"
 	"gorm.io/gorm"
 	"github.com/HersheyPlus/go-auth/api/handlers"
+	"github.com/HersheyPlus/go-auth/utils"
 )
 
-func ProtectedRoutes(default_route *gin.RouterGroup, db *gorm.DB, cfg *config.Config){
+func ProtectedRoutes(default_route *gin.RouterGroup, db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore){
 	protected := default_route.Group("/protected")
-	protected.Use(middlewares.AuthMiddleware(cfg))
-	authHandler := handlers.NewAuthHandler(db, cfg)
+	personalTokens := utils.NewPersonalAccessTokenStore(db)
+	protected.Use(middlewares.AuthMiddleware(cfg, revocations, personalTokens), middlewares.RequireUser())
+	authHandler := handlers.NewAuthHandler(db, cfg, revocations)
+	passwordHandler := handlers.NewPasswordHandler(db, cfg, revocations)
+	profileHandler := handlers.NewProfileHandler(db, cfg)
+	accountHandler := handlers.NewAccountHandler(db, cfg, revocations)
+	sessionHandler := handlers.NewSessionHandler(db, cfg, revocations)
+	personalTokenHandler := handlers.NewPersonalTokenHandler(db, cfg, personalTokens)
+	rbacHandler := handlers.NewRBACHandler(db, cfg)
+	organizationHandler := handlers.NewOrganizationHandler(db, cfg, revocations)
+	mfaHandler := handlers.NewMFAHandler(db, cfg)
+	webAuthnHandler := handlers.NewWebAuthnHandler(db, cfg)
+	// Credentials and the account itself are only managed from a first-party login
+	interactive := middlewares.RequireInteractiveSession()
 	{
-		protected.GET("/profile", authHandler.GetProfile)
+		protected.GET("/profile", middlewares.RequireScopes("users:read"), authHandler.GetProfile)
+		protected.PATCH("/profile", middlewares.RequireScopes("users:write"), profileHandler.UpdateProfile)
 		protected.POST("/logout", authHandler.Logout)
+		protected.POST("/password", middlewares.RequireScopes("users:write"), interactive, passwordHandler.ChangePassword)
+		if cfg.Features.EnableUserDeletion {
+			protected.DELETE("/account", middlewares.RequireScopes("users:write"), interactive, accountHandler.DeleteAccount)
+		}
+
+		protected.GET("/sessions", middlewares.RequireScopes("sessions:read"), sessionHandler.ListSessions)
+		protected.DELETE("/sessions/:id", middlewares.RequireScopes("sessions:write"), sessionHandler.RevokeSession)
+		protected.POST("/sessions/revoke-others", middlewares.RequireScopes("sessions:write"), sessionHandler.RevokeOtherSessions)
+
+		protected.POST("/tokens", middlewares.RequireScopes("tokens:write"), interactive, personalTokenHandler.CreateToken)
+		protected.GET("/tokens", middlewares.RequireScopes("tokens:read"), personalTokenHandler.ListTokens)
+		protected.DELETE("/tokens/:id", middlewares.RequireScopes("tokens:write"), personalTokenHandler.RevokeToken)
+
+		protected.POST("/orgs", middlewares.RequireScopes("orgs:write"), organizationHandler.CreateOrganization)
+		protected.GET("/orgs", middlewares.RequireScopes("orgs:read"), organizationHandler.ListOrganizations)
+		protected.POST("/orgs/:id/switch", middlewares.RequireScopes("orgs:read"), organizationHandler.SwitchOrganization)
+		protected.POST("/invitations/accept", middlewares.RequireScopes("orgs:write"), organizationHandler.AcceptInvitation)
+
+		if cfg.Features.EnableMFA {
+			protected.POST("/mfa/totp", middlewares.RequireScopes("mfa:write"), interactive, mfaHandler.EnrollTOTP)
+			protected.POST("/mfa/totp/confirm", middlewares.RequireScopes("mfa:write"), interactive, mfaHandler.ConfirmTOTP)
+			protected.DELETE("/mfa/totp", middlewares.RequireScopes("mfa:write"), interactive, mfaHandler.DisableTOTP)
+			protected.POST("/mfa/recovery-codes", middlewares.RequireScopes("mfa:write"), interactive, mfaHandler.RegenerateRecoveryCodes)
+		}
+
+		if cfg.Features.EnablePasskeys {
+			protected.POST("/webauthn/register/begin", middlewares.RequireScopes("passkeys:write"), interactive, webAuthnHandler.RegisterBegin)
+			protected.POST("/webauthn/register/finish", middlewares.RequireScopes("passkeys:write"), interactive, webAuthnHandler.RegisterFinish)
+			protected.GET("/webauthn/credentials", middlewares.RequireScopes("passkeys:read"), webAuthnHandler.ListCredentials)
+			protected.DELETE("/webauthn/credentials/:id", middlewares.RequireScopes("passkeys:write"), interactive, webAuthnHandler.DeleteCredential)
+			protected.POST("/webauthn/verify/begin", interactive, webAuthnHandler.VerifyBegin)
+		}
+	}
+
+	// Endpoints for a single organization need a token switched to it
+	org := protected.Group("/orgs/:id", middlewares.RequireOrg())
+	{
+		orgRead := middlewares.RequireScopes("orgs:read")
+		orgWrite := middlewares.RequireScopes("orgs:write")
+		orgManager := middlewares.RequireOrgRole(utils.OrgRoleOwner, utils.OrgRoleAdmin)
+
+		org.GET("/members", orgRead, organizationHandler.ListMembers)
+		org.DELETE("/members/:userId", orgWrite, organizationHandler.RemoveMember)
+
+		org.POST("/invitations", orgWrite, orgManager, organizationHandler.CreateInvitation)
+		org.GET("/invitations", orgRead, orgManager, organizationHandler.ListInvitations)
+		org.DELETE("/invitations/:invitationId", orgWrite, orgManager, organizationHandler.RevokeInvitation)
+	}
+
+	admin := protected.Group("/admin")
+	{
+		rbacRead := middlewares.RequirePermissions(utils.PermissionRBACRead)
+		rbacWrite := middlewares.RequirePermissions(utils.PermissionRBACWrite)
+
+		admin.GET("/roles", rbacRead, rbacHandler.ListRoles)
+		admin.POST("/roles", rbacWrite, rbacHandler.CreateRole)
+		admin.DELETE("/roles/:id", rbacWrite, rbacHandler.DeleteRole)
+		admin.POST("/roles/:id/permissions", rbacWrite, rbacHandler.GrantPermission)
+		admin.DELETE("/roles/:id/permissions/:permissionId", rbacWrite, rbacHandler.RevokePermission)
+
+		admin.GET("/permissions", rbacRead, rbacHandler.ListPermissions)
+		admin.POST("/permissions", rbacWrite, rbacHandler.CreatePermission)
+		admin.DELETE("/permissions/:id", rbacWrite, rbacHandler.DeletePermission)
+
+		admin.GET("/users/:id/roles", rbacRead, rbacHandler.ListUserRoles)
+		admin.POST("/users/:id/roles", rbacWrite, rbacHandler.AssignRole)
+		admin.DELETE("/users/:id/roles/:roleId", rbacWrite, rbacHandler.UnassignRole)
 	}
-}
\ No newline at end of file
+}
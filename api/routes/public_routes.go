@@ -5,14 +5,46 @@ import (
 	"github.com/HersheyPlus/go-auth/api/handlers"
     "gorm.io/gorm"
 	 "github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
 )
 
-func PublicRoutes(default_route *gin.RouterGroup, db *gorm.DB, cfg *config.Config){
+func PublicRoutes(default_route *gin.RouterGroup, db *gorm.DB, cfg *config.Config, revocations utils.RevocationStore){
 
-	authHandler := handlers.NewAuthHandler(db, cfg)
+	authHandler := handlers.NewAuthHandler(db, cfg, revocations)
+	passwordHandler := handlers.NewPasswordHandler(db, cfg, revocations)
+	verificationHandler := handlers.NewEmailVerificationHandler(db, cfg)
+	accountHandler := handlers.NewAccountHandler(db, cfg, revocations)
 	public := default_route.Group("/public")
 	{
 		public.POST("/register", authHandler.Register)
 		public.POST("/login", authHandler.Login)
+		if cfg.Features.EnableMFA {
+			public.POST("/login/mfa", authHandler.LoginMFA)
+			if cfg.Features.EnablePasskeys {
+				public.POST("/login/mfa/webauthn/begin", authHandler.LoginMFAWebAuthnBegin)
+				public.POST("/login/mfa/webauthn/finish", authHandler.LoginMFAWebAuthnFinish)
+			}
+		}
+		if cfg.Features.EnableEmailLogin {
+			public.POST("/login/email-code", authHandler.RequestEmailLogin)
+			public.POST("/login/email-code/verify", authHandler.VerifyEmailLogin)
+		}
+		if cfg.Features.EnablePasskeys {
+			public.POST("/webauthn/login/begin", authHandler.WebAuthnLoginBegin)
+			public.POST("/webauthn/login/finish", authHandler.WebAuthnLoginFinish)
+		}
+		public.POST("/refresh", authHandler.RefreshToken)
+		if cfg.Features.EnablePasswordReset {
+			public.POST("/password/forgot", passwordHandler.ForgotPassword)
+			public.POST("/password/reset", passwordHandler.ResetPassword)
+		}
+		// Always registered as it also confirms email changes
+		public.POST("/email/verify", verificationHandler.VerifyEmail)
+		if cfg.Features.EnableEmailVerification {
+			public.POST("/email/verify/resend", verificationHandler.ResendVerification)
+		}
+		if cfg.Features.EnableUserDeletion {
+			public.POST("/account/restore", accountHandler.RestoreAccount)
+		}
 	}
 }
\ No newline at end of file
@@ -3,12 +3,16 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
 	"gorm.io/gorm"
 )
 
 func SetUpRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config){
 	r.GET("/health")
+	WellKnownRoutes(r, cfg)
+	revocations := utils.NewRevocationStore(db, &cfg.Security.Revocation)
+	OAuthRoutes(r, db, cfg, revocations)
 	default_route := r.Group(cfg.App.API.Prefix + "/" + cfg.App.API.Version)
-	PublicRoutes(default_route, db, cfg)
-	ProtectedRoutes(default_route, db, cfg)
+	PublicRoutes(default_route, db, cfg, revocations)
+	ProtectedRoutes(default_route, db, cfg, revocations)
 }
\ No newline at end of file
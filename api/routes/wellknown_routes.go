@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/HersheyPlus/go-auth/api/handlers"
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/gin-gonic/gin"
+)
+
+func WellKnownRoutes(r *gin.Engine, cfg *config.Config) {
+	wellKnownHandler := handlers.NewWellKnownHandler(cfg)
+	wellKnown := r.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", wellKnownHandler.JWKS)
+		wellKnown.GET("/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+	}
+}
@@ -2,6 +2,7 @@ package validators
 
 import (
 	"fmt"
+	"strings"
 	"github.com/HersheyPlus/go-auth/config"
 	"github.com/HersheyPlus/go-auth/dto"
 	"github.com/HersheyPlus/go-auth/utils"
@@ -21,6 +22,9 @@ func ValidateRegisterFields(req *dto.UserRegisterRequest, cfg *config.SecurityCo
 	if req.Password == "" {
 		return fmt.Errorf("password is required")
 	}
+	if err := validateUsername(req.Username); err != nil {
+		return err
+	}
 
 	// Validate password requirements
 	if err := utils.ValidatePassword(req.Password, cfg); err != nil {
@@ -28,4 +32,40 @@ func ValidateRegisterFields(req *dto.UserRegisterRequest, cfg *config.SecurityCo
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// reservedUsernames could be mistaken for the service or its staff
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true,
+	"support": true, "security": true, "help": true, "staff": true,
+	"moderator": true, "official": true, "api": true, "oauth": true,
+	"auth": true, "login": true, "signup": true, "register": true,
+	"account": true, "settings": true, "null": true, "undefined": true,
+}
+
+func ValidateUpdateFields(req *dto.UserUpdateRequest) error {
+	// The password has its own endpoint, which checks the current one
+	if req.Password != nil {
+		return fmt.Errorf("password cannot be changed here, use the change password endpoint")
+	}
+
+	if req.Username != nil {
+		if err := validateUsername(*req.Username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateUsername applies the rules every username follows, at registration
+// and when it is changed
+func validateUsername(username string) error {
+	if strings.TrimSpace(username) != username {
+		return fmt.Errorf("username cannot start or end with whitespace")
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return fmt.Errorf("username %q is reserved", username)
+	}
+	return nil
+}
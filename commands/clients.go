@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
+	"gorm.io/gorm"
+)
+
+const clientsUsage = `usage: go-auth clients <command>
+
+commands:
+  list                  list the registered clients
+  create -name <name> [-public] [-redirect-uris a,b] [-grants a,b] [-scopes a,b]
+                        register a client and print its secret once
+  create -name <name> -service [-scopes a,b]
+                        register a service account for the client credentials grant
+  delete <client_id>    remove a client`
+
+// RunClients executes the OAuth client administration command
+func RunClients(cfg *config.Config, db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(clientsUsage)
+	}
+
+	ctx := context.Background()
+	store := utils.NewClientStore(db)
+
+	switch args[0] {
+	case "list":
+		clients, err := store.ListClients(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CLIENT ID\tNAME\tTYPE\tGRANTS\tSCOPES\tCREATED")
+		for _, client := range clients {
+			clientType := "public"
+			if client.Confidential {
+				clientType = "confidential"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", client.ClientID, client.Name, clientType,
+				strings.Join(client.AllowedGrants, ","), strings.Join(client.Scopes, ","),
+				client.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ContinueOnError)
+		name := flags.String("name", "", "client name")
+		public := flags.Bool("public", false, "public client without a secret, PKCE is required")
+		service := flags.Bool("service", false, "service account using the client credentials grant")
+		redirectURIs := flags.String("redirect-uris", "", "comma separated redirect URIs")
+		grants := flags.String("grants", utils.GrantAuthorizationCode+","+utils.GrantRefreshToken, "comma separated allowed grant types")
+		scopes := flags.String("scopes", "", "comma separated allowed scopes")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" || (*service && *public) {
+			return errors.New(clientsUsage)
+		}
+		if *service {
+			*grants = utils.GrantClientCredentials
+		}
+		client, secret, err := store.CreateClient(ctx, utils.ClientOptions{
+			Name:          *name,
+			Confidential:  !*public,
+			RedirectURIs:  splitList(*redirectURIs),
+			AllowedGrants: splitList(*grants),
+			Scopes:        splitList(*scopes),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("client_id:     %s\n", client.ClientID)
+		if secret != "" {
+			fmt.Printf("client_secret: %s\n\nStore the secret now, it cannot be shown again.\n", secret)
+		}
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return errors.New(clientsUsage)
+		}
+		if err := store.DeleteClient(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted client %s\n", args[1])
+		return nil
+	default:
+		return errors.New(clientsUsage)
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
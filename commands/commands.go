@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"gorm.io/gorm"
+)
+
+const usage = `usage: go-auth [command]
+
+Without a command the HTTP server is started.
+
+commands:
+  keys      manage the JWT signing keyring
+  clients   manage registered OAuth clients
+  roles     list roles and assign them to users
+  outbox    inspect and retry dead-lettered notifications`
+
+// Run executes the administrative command named by args[0]
+func Run(cfg *config.Config, db *gorm.DB, args []string) error {
+	switch args[0] {
+	case "keys":
+		return RunKeys(cfg, db, args[1:])
+	case "clients":
+		return RunClients(cfg, db, args[1:])
+	case "roles":
+		return RunRoles(cfg, db, args[1:])
+	case "outbox":
+		return RunOutbox(cfg, db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
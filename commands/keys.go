@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
+	"gorm.io/gorm"
+)
+
+const keysUsage = `usage: go-auth keys <command>
+
+commands:
+  list                                    list the keys in the keyring
+  generate [-purpose access|refresh] [-alg HS256|RS256|ES256|EdDSA]
+                                          add a verify-only key, published before it signs
+  promote <kid>                           make a key the active signing key
+  retire                                  delete verify-only keys past their retire time`
+
+// RunKeys executes the signing key administration command
+func RunKeys(cfg *config.Config, db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	ctx := context.Background()
+	store := utils.NewKeyStore(db)
+
+	switch args[0] {
+	case "list":
+		return listKeys(ctx, store)
+	case "generate":
+		flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+		purpose := flags.String("purpose", utils.KeyPurposeAccess, "key purpose: access or refresh")
+		algorithm := flags.String("alg", cfg.JWT.Algorithm, "signing algorithm")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		key, err := store.Generate(ctx, *purpose, *algorithm)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated %s %s key %s (verify-only)\n", key.Algorithm, key.Purpose, key.KID)
+		return nil
+	case "promote":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		if err := store.Promote(ctx, args[1], &cfg.JWT); err != nil {
+			return err
+		}
+		fmt.Printf("Promoted key %s, the previous key is verify-only until its tokens expire\n", args[1])
+		return nil
+	case "retire":
+		kids, err := store.Retire(ctx)
+		if err != nil {
+			return err
+		}
+		for _, kid := range kids {
+			fmt.Printf("Retired key %s\n", kid)
+		}
+		if len(kids) == 0 {
+			fmt.Println("No keys are due for retirement")
+		}
+		return nil
+	default:
+		return errors.New(keysUsage)
+	}
+}
+
+func listKeys(ctx context.Context, store *utils.KeyStore) error {
+	keys, err := store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tPURPOSE\tALG\tSTATUS\tACTIVATED\tRETIRE AFTER")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.KID, key.Purpose, key.Algorithm, key.Status,
+			formatTime(key.ActivatedAt), formatTime(key.RetireAfter))
+	}
+	return w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
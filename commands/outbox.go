@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const outboxUsage = `usage: go-auth outbox <command>
+
+commands:
+  dead          list the dead-lettered messages
+  retry <id>    queue a dead-lettered message for delivery again`
+
+// RunOutbox executes the outbox administration command
+func RunOutbox(cfg *config.Config, db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(outboxUsage)
+	}
+
+	ctx := context.Background()
+	store := utils.NewOutboxStore(db)
+
+	switch args[0] {
+	case "dead":
+		messages, err := store.ListDead(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTOPIC\tATTEMPTS\tCREATED\tLAST ERROR")
+		for _, msg := range messages {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", msg.ID, msg.Topic, msg.Attempts,
+				msg.CreatedAt.Format(time.RFC3339), msg.LastError)
+		}
+		return w.Flush()
+	case "retry":
+		if len(args) != 2 {
+			return errors.New(outboxUsage)
+		}
+		id, err := uuid.Parse(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid message id: %w", err)
+		}
+		if err := store.Retry(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Queued message %s for delivery\n", id)
+		return nil
+	default:
+		return errors.New(outboxUsage)
+	}
+}
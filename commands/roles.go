@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/HersheyPlus/go-auth/utils"
+	"gorm.io/gorm"
+)
+
+const rolesUsage = `usage: go-auth roles <command>
+
+commands:
+  list                     list the roles and their permissions
+  assign <email> <role>    give a role to a user, e.g. to bootstrap the first admin
+  unassign <email> <role>  take a role away from a user`
+
+// RunRoles executes the role administration command
+func RunRoles(cfg *config.Config, db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(rolesUsage)
+	}
+
+	ctx := context.Background()
+	store := utils.NewRBACStore(db)
+
+	switch args[0] {
+	case "list":
+		roles, err := store.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPERMISSIONS")
+		for _, role := range roles {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", role.ID, role.Name, strings.Join(role.Permissions, ","))
+		}
+		return w.Flush()
+	case "assign", "unassign":
+		if len(args) != 3 {
+			return errors.New(rolesUsage)
+		}
+
+		var user models.User
+		if err := db.WithContext(ctx).Where("email = ?", strings.ToLower(args[1])).First(&user).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return utils.ErrUserNotFound
+			}
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+		var role models.Role
+		if err := db.WithContext(ctx).Where("name = ?", args[2]).First(&role).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return utils.ErrRoleNotFound
+			}
+			return fmt.Errorf("failed to load role: %w", err)
+		}
+
+		if args[0] == "assign" {
+			if err := store.AssignRole(ctx, user.UserID, role.ID); err != nil {
+				return err
+			}
+			fmt.Printf("Assigned role %s to %s\n", role.Name, user.Email)
+			return nil
+		}
+		if err := store.UnassignRole(ctx, user.UserID, role.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Removed role %s from %s\n", role.Name, user.Email)
+		return nil
+	default:
+		return errors.New(rolesUsage)
+	}
+}
@@ -56,6 +56,12 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("database.conn_max_lifetime", "1h")
 	v.SetDefault("database.ssl_mode", "disable")
 
+	// JWT defaults
+	v.SetDefault("jwt.algorithm", "HS256")
+	v.SetDefault("jwt.issuer", "go-auth-service")
+	v.SetDefault("jwt.audience", "users")
+	v.SetDefault("jwt.keyring_reload_interval", "1m")
+
 	// Rate limit defaults
 	v.SetDefault("rate_limit.enabled", true)
 	v.SetDefault("rate_limit.requests", 100)
@@ -66,6 +72,20 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.bcrypt_cost", 12)
 	v.SetDefault("security.min_password_length", 8)
 	v.SetDefault("security.max_password_length", 72)
+	v.SetDefault("security.max_sessions_per_user", 10)
+	v.SetDefault("security.revocation.store", "postgres")
+	v.SetDefault("security.revocation.cache_ttl", "10s")
+	v.SetDefault("security.mfa.challenge_ttl", "5m")
+	v.SetDefault("security.mfa.max_failed_attempts", 5)
+	v.SetDefault("security.mfa.lockout_duration", "5m")
+	v.SetDefault("security.email_login.code_ttl", "10m")
+	v.SetDefault("security.email_login.max_attempts", 5)
+	v.SetDefault("security.email_login.lockout_duration", "15m")
+	v.SetDefault("security.password_reset.token_ttl", "1h")
+	v.SetDefault("security.email_verification.token_ttl", "24h")
+	v.SetDefault("security.email_verification.unverified_login", "allow")
+	v.SetDefault("security.account_deletion.grace_period", "720h")
+	v.SetDefault("security.account_deletion.purge_interval", "1h")
 
 	// App defaults
 	v.SetDefault("app.environment", "development")
@@ -74,6 +94,30 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("app.api.version", "v1")
 	v.SetDefault("app.api.prefix", "/api")
 
+	// OAuth defaults
+	v.SetDefault("oauth.authorization_code_ttl", "1m")
+	v.SetDefault("oauth.id_token_ttl", "1h")
+
+	// Organization defaults
+	v.SetDefault("organizations.invitation_ttl", "168h")
+	v.SetDefault("webauthn.challenge_ttl", "5m")
+
+	// Outbox defaults
+	v.SetDefault("outbox.poll_interval", "5s")
+	v.SetDefault("outbox.batch_size", 50)
+	v.SetDefault("outbox.max_attempts", 10)
+	v.SetDefault("outbox.initial_backoff", "30s")
+	v.SetDefault("outbox.max_backoff", "1h")
+	v.SetDefault("outbox.lease_timeout", "1m")
+	v.SetDefault("outbox.dead_retention", "720h")
+	v.SetDefault("outbox.webhook.timeout", "10s")
+
+	// Email defaults
+	v.SetDefault("email.transport", "smtp")
+	v.SetDefault("email.smtp.encryption", "tls")
+	v.SetDefault("email.smtp.timeout", "10s")
+	v.SetDefault("email.capture_dir", "tmp/mail")
+
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
@@ -81,14 +125,124 @@ func setDefaults(v *viper.Viper) {
 }
 
 func validateConfig(cfg *Config) error {
-	if cfg.JWT.SecretKey == "" {
-		return fmt.Errorf("jwt secret is required")
+	switch cfg.JWT.Algorithm {
+	case "HS256":
+		if cfg.JWT.SecretKey == "" {
+			return fmt.Errorf("jwt secret is required")
+		}
+	case "RS256", "ES256", "EdDSA":
+		if cfg.JWT.PrivateKeyPath == "" {
+			return fmt.Errorf("jwt private key path is required for %s", cfg.JWT.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unsupported jwt algorithm: %s", cfg.JWT.Algorithm)
+	}
+
+	if cfg.JWT.RefreshKey == "" {
+		return fmt.Errorf("jwt refresh key is required")
+	}
+	if cfg.JWT.KeyringReloadInterval <= 0 {
+		return fmt.Errorf("jwt keyring reload interval must be greater than 0")
 	}
 
 	if cfg.Database.User == "" || cfg.Database.Password == "" || cfg.Database.Name == "" {
 		return fmt.Errorf("database configuration is incomplete")
 	}
 
+	if store := cfg.Security.Revocation.Store; store != "memory" && store != "postgres" {
+		return fmt.Errorf("unsupported revocation store: %s", store)
+	}
+
+	if cfg.Features.EnableMFA {
+		if cfg.Security.MFA.ChallengeTTL <= 0 {
+			return fmt.Errorf("mfa challenge ttl must be greater than 0")
+		}
+		if cfg.Security.MFA.MaxFailedAttempts <= 0 {
+			return fmt.Errorf("mfa max failed attempts must be greater than 0")
+		}
+	}
+
+	if cfg.Features.EnableEmailLogin {
+		if cfg.Security.EmailLogin.CodeTTL <= 0 {
+			return fmt.Errorf("email login code ttl must be greater than 0")
+		}
+		if cfg.Security.EmailLogin.MaxAttempts <= 0 {
+			return fmt.Errorf("email login max attempts must be greater than 0")
+		}
+		if cfg.Security.EmailLogin.LockoutDuration <= 0 {
+			return fmt.Errorf("email login lockout duration must be greater than 0")
+		}
+	}
+
+	if cfg.Features.EnablePasswordReset && cfg.Security.PasswordReset.TokenTTL <= 0 {
+		return fmt.Errorf("password reset token ttl must be greater than 0")
+	}
+
+	if cfg.Features.EnableEmailVerification {
+		if cfg.Security.EmailVerification.TokenTTL <= 0 {
+			return fmt.Errorf("email verification token ttl must be greater than 0")
+		}
+		switch cfg.Security.EmailVerification.UnverifiedLogin {
+		case "allow", "limited", "reject":
+		default:
+			return fmt.Errorf("unsupported unverified login policy: %s", cfg.Security.EmailVerification.UnverifiedLogin)
+		}
+	}
+
+	if cfg.Features.EnableUserDeletion {
+		if cfg.Security.AccountDeletion.GracePeriod < 0 {
+			return fmt.Errorf("account deletion grace period cannot be negative")
+		}
+		if cfg.Security.AccountDeletion.PurgeInterval <= 0 {
+			return fmt.Errorf("account deletion purge interval must be greater than 0")
+		}
+	}
+
+	if cfg.Features.EnablePasskeys {
+		if cfg.WebAuthn.RPID == "" || len(cfg.WebAuthn.Origins) == 0 {
+			return fmt.Errorf("webauthn rp_id and origins are required for passkeys")
+		}
+		if cfg.WebAuthn.ChallengeTTL <= 0 {
+			return fmt.Errorf("webauthn challenge ttl must be greater than 0")
+		}
+	}
+
+	if cfg.Email.Enabled {
+		switch cfg.Email.Transport {
+		case "smtp":
+			if cfg.Email.SMTP.Host == "" || cfg.Email.SMTP.Port <= 0 {
+				return fmt.Errorf("smtp host and port are required")
+			}
+			switch cfg.Email.SMTP.Encryption {
+			case "tls", "ssl", "none":
+			default:
+				return fmt.Errorf("unsupported smtp encryption: %s", cfg.Email.SMTP.Encryption)
+			}
+		case "file":
+			if cfg.Email.CaptureDir == "" {
+				return fmt.Errorf("email capture dir is required for the file transport")
+			}
+		default:
+			return fmt.Errorf("unsupported email transport: %s", cfg.Email.Transport)
+		}
+		if cfg.Email.From.Email == "" {
+			return fmt.Errorf("email from address is required")
+		}
+	}
+
+	if cfg.Outbox.PollInterval <= 0 || cfg.Outbox.BatchSize <= 0 || cfg.Outbox.MaxAttempts <= 0 {
+		return fmt.Errorf("outbox poll interval, batch size and max attempts must be greater than 0")
+	}
+	if cfg.Outbox.InitialBackoff <= 0 || cfg.Outbox.MaxBackoff < cfg.Outbox.InitialBackoff {
+		return fmt.Errorf("outbox backoff must be greater than 0 and max backoff at least the initial one")
+	}
+	if cfg.Outbox.LeaseTimeout <= 0 {
+		return fmt.Errorf("outbox lease timeout must be greater than 0")
+	}
+	if cfg.Outbox.DeadRetention <= 0 {
+		return fmt.Errorf("outbox dead letter retention must be greater than 0")
+	}
+
 	// Validate rate limit configuration
 	if cfg.RateLimit.Enabled {
 		if cfg.RateLimit.Requests <= 0 {
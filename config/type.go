@@ -5,19 +5,23 @@ import (
 )
 
 type Config struct {
-	Server     ServerConfig     `mapstructure:"server"`
-	Database   DatabaseConfig   `mapstructure:"database"`
-	JWT        JWTConfig        `mapstructure:"jwt"`
-	CORS       CORSConfig       `mapstructure:"cors"`
-	RateLimit  RateLimitConfig  `mapstructure:"rate_limit"`
-	Security   SecurityConfig   `mapstructure:"security"`
-	App        AppConfig        `mapstructure:"app"`
-	Logging    LoggingConfig    `mapstructure:"logging"`
-	Cache      CacheConfig      `mapstructure:"cache"`
-	Monitoring MonitoringConfig `mapstructure:"monitoring"`
-	Email      EmailConfig      `mapstructure:"email"`
-	Storage    StorageConfig    `mapstructure:"storage"`
-	Features   FeaturesConfig   `mapstructure:"features"`
+	Server        ServerConfig       `mapstructure:"server"`
+	Database      DatabaseConfig     `mapstructure:"database"`
+	JWT           JWTConfig          `mapstructure:"jwt"`
+	CORS          CORSConfig         `mapstructure:"cors"`
+	RateLimit     RateLimitConfig    `mapstructure:"rate_limit"`
+	Security      SecurityConfig     `mapstructure:"security"`
+	App           AppConfig          `mapstructure:"app"`
+	Logging       LoggingConfig      `mapstructure:"logging"`
+	Cache         CacheConfig        `mapstructure:"cache"`
+	Monitoring    MonitoringConfig   `mapstructure:"monitoring"`
+	Email         EmailConfig        `mapstructure:"email"`
+	Storage       StorageConfig      `mapstructure:"storage"`
+	Features      FeaturesConfig     `mapstructure:"features"`
+	OAuth         OAuthConfig        `mapstructure:"oauth"`
+	Organizations OrganizationConfig `mapstructure:"organizations"`
+	WebAuthn      WebAuthnConfig     `mapstructure:"webauthn"`
+	Outbox        OutboxConfig       `mapstructure:"outbox"`
 }
 
 type ServerConfig struct {
@@ -53,6 +57,12 @@ type JWTConfig struct {
 	RefreshTokenExpiry time.Duration `mapstructure:"refresh_token_expiry"`
 	Issuer             string        `mapstructure:"issuer"`
 	Audience           string        `mapstructure:"audience"`
+	AcceptedAudiences  []string      `mapstructure:"accepted_audiences"`
+	Algorithm          string        `mapstructure:"algorithm"`
+	PrivateKeyPath     string        `mapstructure:"private_key_path"`
+	KeyID              string        `mapstructure:"key_id"`
+	// KeyringReloadInterval controls how often keys promoted elsewhere are picked up
+	KeyringReloadInterval time.Duration `mapstructure:"keyring_reload_interval"`
 }
 
 type CORSConfig struct {
@@ -72,12 +82,56 @@ type RateLimitConfig struct {
 }
 
 type SecurityConfig struct {
-	BCryptCost           int                  `mapstructure:"bcrypt_cost"`
-	MinPasswordLength    int                  `mapstructure:"min_password_length"`
-	MaxPasswordLength    int                  `mapstructure:"max_password_length"`
-	AllowedSpecialChars  string               `mapstructure:"allowed_special_chars"`
-	PasswordRequirements PasswordRequirements `mapstructure:"password_requirements"`
+	BCryptCost           int                     `mapstructure:"bcrypt_cost"`
+	MinPasswordLength    int                     `mapstructure:"min_password_length"`
+	MaxPasswordLength    int                     `mapstructure:"max_password_length"`
+	AllowedSpecialChars  string                  `mapstructure:"allowed_special_chars"`
+	PasswordRequirements PasswordRequirements    `mapstructure:"password_requirements"`
     TrackRefreshTokens bool `mapstructure:"track_refresh_tokens"`
+	MaxSessionsPerUser   int                     `mapstructure:"max_sessions_per_user"`
+	Revocation           RevocationConfig        `mapstructure:"revocation"`
+	MFA                  MFAConfig               `mapstructure:"mfa"`
+	EmailLogin           EmailLoginConfig        `mapstructure:"email_login"`
+	PasswordReset        PasswordResetConfig     `mapstructure:"password_reset"`
+	EmailVerification    EmailVerificationConfig `mapstructure:"email_verification"`
+	AccountDeletion      AccountDeletionConfig   `mapstructure:"account_deletion"`
+}
+
+type MFAConfig struct {
+	Issuer            string        `mapstructure:"issuer"` // shown in authenticator apps, defaults to app.name
+	ChallengeTTL      time.Duration `mapstructure:"challenge_ttl"`
+	MaxFailedAttempts int           `mapstructure:"max_failed_attempts"`
+	LockoutDuration   time.Duration `mapstructure:"lockout_duration"`
+}
+
+type EmailLoginConfig struct {
+	CodeTTL         time.Duration `mapstructure:"code_ttl"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`     // wrong codes before the code is spent and email login locked
+	LockoutDuration time.Duration `mapstructure:"lockout_duration"` // how long email login stays locked
+	LinkURL         string        `mapstructure:"link_url"`         // page the magic link opens, empty sends the code only
+}
+
+type PasswordResetConfig struct {
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+	LinkURL  string        `mapstructure:"link_url"` // page the reset link opens, empty sends the token only
+}
+
+type EmailVerificationConfig struct {
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+	LinkURL  string        `mapstructure:"link_url"` // page the verification link opens, empty sends the token only
+	// UnverifiedLogin is what users who have not verified their email get when
+	// signing in: "allow" full tokens, "limited" tokens or "reject"
+	UnverifiedLogin string `mapstructure:"unverified_login"`
+}
+
+type AccountDeletionConfig struct {
+	GracePeriod   time.Duration `mapstructure:"grace_period"`   // deleted accounts can be restored until it ends
+	PurgeInterval time.Duration `mapstructure:"purge_interval"` // how often accounts past the grace period are purged
+}
+
+type RevocationConfig struct {
+	Store    string        `mapstructure:"store"`
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
 }
 
 type PasswordRequirements struct {
@@ -129,18 +183,21 @@ type MonitoringConfig struct {
 }
 
 type EmailConfig struct {
-	Enabled      bool       `mapstructure:"enabled"`
+	Enabled      bool       `mapstructure:"enabled"` // disabled only logs outgoing mail
+	Transport    string     `mapstructure:"transport"`
 	SMTP         SMTPConfig `mapstructure:"smtp"`
 	From         FromConfig `mapstructure:"from"`
-	TemplatesDir string     `mapstructure:"templates_dir"`
+	TemplatesDir string     `mapstructure:"templates_dir"` // overrides the built-in templates
+	CaptureDir   string     `mapstructure:"capture_dir"`   // where the file transport writes messages
 }
 
 type SMTPConfig struct {
-	Host       string `mapstructure:"host"`
-	Port       int    `mapstructure:"port"`
-	Username   string `mapstructure:"username"`
-	Password   string `mapstructure:"password"`
-	Encryption string `mapstructure:"encryption"`
+	Host       string        `mapstructure:"host"`
+	Port       int           `mapstructure:"port"`
+	Username   string        `mapstructure:"username"`
+	Password   string        `mapstructure:"password"`
+	Encryption string        `mapstructure:"encryption"`
+	Timeout    time.Duration `mapstructure:"timeout"`
 }
 
 type FromConfig struct {
@@ -173,4 +230,43 @@ type FeaturesConfig struct {
 	EnablePasswordReset     bool `mapstructure:"enable_password_reset"`
 	EnableEmailVerification bool `mapstructure:"enable_email_verification"`
 	EnableUserDeletion      bool `mapstructure:"enable_user_deletion"`
+	EnablePasskeys          bool `mapstructure:"enable_passkeys"`
+	EnableEmailLogin        bool `mapstructure:"enable_email_login"`
+}
+
+type OAuthConfig struct {
+	AuthorizationCodeTTL time.Duration `mapstructure:"authorization_code_ttl"`
+	// PublicURL is the externally reachable base URL used in the discovery
+	// document, derived from the request when empty
+	PublicURL            string        `mapstructure:"public_url"`
+	IDTokenTTL           time.Duration `mapstructure:"id_token_ttl"`
+}
+
+type OrganizationConfig struct {
+	InvitationTTL time.Duration `mapstructure:"invitation_ttl"`
+	LinkURL       string        `mapstructure:"link_url"` // page the invitation link opens, empty sends the token only
+}
+
+type WebAuthnConfig struct {
+	RPID         string        `mapstructure:"rp_id"`   // registrable domain credentials are scoped to
+	RPName       string        `mapstructure:"rp_name"` // defaults to app.name
+	Origins      []string      `mapstructure:"origins"` // origins the browser ceremonies may run on
+	ChallengeTTL time.Duration `mapstructure:"challenge_ttl"`
+}
+
+type OutboxConfig struct {
+	PollInterval   time.Duration `mapstructure:"poll_interval"`
+	BatchSize      int           `mapstructure:"batch_size"`
+	MaxAttempts    int           `mapstructure:"max_attempts"` // failed deliveries before an entry is dead-lettered
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	LeaseTimeout   time.Duration `mapstructure:"lease_timeout"`  // claimed entries are retried after this if never settled
+	DeadRetention  time.Duration `mapstructure:"dead_retention"` // dead-lettered entries are purged after this
+	Webhook        WebhookConfig `mapstructure:"webhook"`
+}
+
+type WebhookConfig struct {
+	URL     string        `mapstructure:"url"`    // receives user events, empty discards them
+	Secret  string        `mapstructure:"secret"` // signs the body in X-Webhook-Signature
+	Timeout time.Duration `mapstructure:"timeout"`
 }
@@ -44,7 +44,31 @@ func runMigrations() error {
 	// Run migrations in specific order
 	if err := db.AutoMigrate(
 		&models.User{},
+		&models.Role{},
+		&models.Permission{},
+		&models.RolePermission{},
+		&models.UserRole{},
 		&models.RefreshToken{}, // Move RefreshToken to models package
+		&models.Session{},
+		&models.SigningKey{},
+		&models.RevokedToken{},
+		&models.TokenCutoff{},
+		&models.OAuthClient{},
+		&models.AuthorizationCode{},
+		&models.PersonalAccessToken{},
+		&models.Organization{},
+		&models.Membership{},
+		&models.Invitation{},
+		&models.TOTPAuthenticator{},
+		&models.RecoveryCode{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.EmailLoginChallenge{},
+		&models.FailedAttempt{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.OutboxMessage{},
+		&models.AuditEvent{},
 	); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
@@ -24,7 +24,35 @@ type UserLoginResponse struct {
     LastLogin time.Time `json:"last_login,omitempty"`
 }
 
+// MFAChallengeResponse is returned by login instead of the token pair when the
+// user has a second factor. Methods lists the factors the user can answer with.
+type MFAChallengeResponse struct {
+    MFARequired bool     `json:"mfa_required"`
+    MFAToken    string   `json:"mfa_token"`
+    Methods     []string `json:"methods"`
+    ExpiresIn   int64    `json:"expires_in"`
+}
+
+// TOTPEnrollmentResponse carries the new secret for the user's authenticator
+// app, as text, as an otpauth URI and as a QR code PNG data URI
+type TOTPEnrollmentResponse struct {
+    Secret     string `json:"secret"`
+    OTPAuthURI string `json:"otpauth_uri"`
+    QRCode     string `json:"qr_code"`
+}
 
+// RecoveryCodesResponse carries a new set of recovery codes, which are only
+// shown once
+type RecoveryCodesResponse struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TokenRefreshResponse struct {
+    AccessToken  string `json:"access_token"`
+    RefreshToken string `json:"refresh_token"`
+    TokenType    string `json:"token_type"`
+    ExpiresIn    int64  `json:"expires_in"`
+}
 
 type UserProfileResponse struct {
     UserID        uuid.UUID `json:"id"`
@@ -33,6 +61,74 @@ type UserProfileResponse struct {
     LastName  *string   `json:"last_name,omitempty"`
     Phone     string    `json:"phone"`
     Email     string    `json:"email"`
+    EmailVerified bool  `json:"email_verified"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
-}
\ No newline at end of file
+}
+
+type PersonalAccessTokenResponse struct {
+    ID         uuid.UUID  `json:"id"`
+    Name       string     `json:"name"`
+    TokenHint  string     `json:"token_hint"`
+    Scopes     []string   `json:"scopes"`
+    ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+    LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatedPersonalAccessTokenResponse carries the plain token, which is only
+// returned once
+type CreatedPersonalAccessTokenResponse struct {
+    PersonalAccessTokenResponse
+    Token string `json:"token"`
+}
+
+type RoleResponse struct {
+    ID          uuid.UUID `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description,omitempty"`
+    Permissions []string  `json:"permissions,omitempty"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+type PermissionResponse struct {
+    ID          uuid.UUID `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description,omitempty"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+type OrganizationResponse struct {
+    ID        uuid.UUID  `json:"id"`
+    Name      string     `json:"name"`
+    Role      string     `json:"role"`
+    JoinedAt  *time.Time `json:"joined_at,omitempty"`
+    CreatedAt time.Time  `json:"created_at"`
+}
+
+type MemberResponse struct {
+    UserID   uuid.UUID `json:"user_id"`
+    Username string    `json:"username"`
+    Email    string    `json:"email"`
+    Role     string    `json:"role"`
+    JoinedAt time.Time `json:"joined_at"`
+}
+
+type InvitationResponse struct {
+    ID             uuid.UUID `json:"id"`
+    OrganizationID uuid.UUID `json:"organization_id"`
+    Email          string    `json:"email"`
+    Role           string    `json:"role"`
+    ExpiresAt      time.Time `json:"expires_at"`
+    CreatedAt      time.Time `json:"created_at"`
+}
+
+type SessionResponse struct {
+    ID         uuid.UUID `json:"id"`
+    DeviceName string    `json:"device_name,omitempty"`
+    UserAgent  string    `json:"user_agent,omitempty"`
+    IPAddress  string    `json:"ip_address,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+    LastUsedAt time.Time `json:"last_used_at"`
+    Current    bool      `json:"current"`
+}
@@ -1,6 +1,8 @@
 package dto
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -14,10 +16,133 @@ type UserRegisterRequest struct {
 }
 
 type UserLoginRequest struct {
+    Email      string `json:"email" binding:"required,email"`
+    Password   string `json:"password" binding:"required"`
+    DeviceName string `json:"device_name,omitempty" binding:"omitempty,max=100"`
+}
+
+type MFALoginRequest struct {
+    MFAToken     string `json:"mfa_token" binding:"required"`
+    Code         string `json:"code,omitempty" binding:"required_without=RecoveryCode"`
+    RecoveryCode string `json:"recovery_code,omitempty" binding:"omitempty,max=20"`
+    DeviceName   string `json:"device_name,omitempty" binding:"omitempty,max=100"`
+}
+
+type EmailLoginRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+// EmailLoginVerifyRequest redeems an emailed login with the code and its
+// address, or with the magic link token
+type EmailLoginVerifyRequest struct {
+    Email      string `json:"email,omitempty" binding:"required_without=Token,omitempty,email"`
+    Code       string `json:"code,omitempty" binding:"required_without=Token,omitempty,len=6,numeric"`
+    Token      string `json:"token,omitempty"`
+    DeviceName string `json:"device_name,omitempty" binding:"omitempty,max=100"`
+}
+
+type ForgotPasswordRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+    Token    string `json:"token" binding:"required"`
+    Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+    CurrentPassword     string `json:"current_password" binding:"required"`
+    NewPassword         string `json:"new_password" binding:"required"`
+    RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+}
+
+// DeleteAccountRequest re-authenticates the user with the password and, when
+// a second factor is set up, an answer from it
+type DeleteAccountRequest struct {
+    Password string `json:"password" binding:"required"`
+    SecondFactorAnswer
+}
+
+type RestoreAccountRequest struct {
     Email    string `json:"email" binding:"required,email"`
     Password string `json:"password" binding:"required"`
 }
 
+type VerifyEmailRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+type ConfirmTOTPRequest struct {
+    Code string `json:"code" binding:"required"`
+}
+
+// SecondFactorAnswer confirms a sensitive action with an authenticator code, a
+// recovery code or a passkey assertion started by /webauthn/verify/begin
+type SecondFactorAnswer struct {
+    Code               string               `json:"code,omitempty"`
+    RecoveryCode       string               `json:"recovery_code,omitempty" binding:"omitempty,max=20"`
+    WebAuthnSessionID  uuid.UUID            `json:"webauthn_session_id,omitempty" binding:"required_with=WebAuthnCredential"`
+    WebAuthnCredential *AssertionCredential `json:"webauthn_credential,omitempty"`
+}
+
+// MFACodeRequest answers the second factor before authenticators or recovery
+// codes are changed
+type MFACodeRequest struct {
+    SecondFactorAnswer
+}
+
+type RefreshTokenRequest struct {
+    RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type CreatePersonalAccessTokenRequest struct {
+    Name      string     `json:"name" binding:"required,min=1,max=100"`
+    Scopes    []string   `json:"scopes,omitempty" binding:"omitempty,dive,min=1,max=100"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type CreateRoleRequest struct {
+    Name        string `json:"name" binding:"required,min=2,max=50"`
+    Description string `json:"description,omitempty" binding:"omitempty,max=255"`
+}
+
+type CreatePermissionRequest struct {
+    Name        string `json:"name" binding:"required,min=2,max=100"`
+    Description string `json:"description,omitempty" binding:"omitempty,max=255"`
+}
+
+type GrantPermissionRequest struct {
+    PermissionID uuid.UUID `json:"permission_id" binding:"required"`
+}
+
+type AssignRoleRequest struct {
+    RoleID uuid.UUID `json:"role_id" binding:"required"`
+}
+
+type CreateOrganizationRequest struct {
+    Name string `json:"name" binding:"required,min=2,max=100"`
+}
+
+type SwitchOrganizationRequest struct {
+    RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type CreateInvitationRequest struct {
+    Email string `json:"email" binding:"required,email,max=100"`
+    Role  string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+type AcceptInvitationRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+// UserUpdateRequest changes the fields present. A new email address is only
+// accepted with the current password and, when a second factor is set up, an
+// answer from it.
 type UserUpdateRequest struct {
     Username  *string `json:"username,omitempty" binding:"omitempty,min=3,max=100"`
     FirstName *string `json:"first_name,omitempty" binding:"omitempty,min=2,max=100"`
@@ -25,6 +150,9 @@ type UserUpdateRequest struct {
     Phone     *string `json:"phone,omitempty" binding:"omitempty,min=10,max=20"`
     Email     *string `json:"email,omitempty" binding:"omitempty,email,max=100"`
     Password  *string `json:"password,omitempty" binding:"omitempty,min=4,max=72"`
+
+    CurrentPassword string `json:"current_password,omitempty"`
+    SecondFactorAnswer
 }
 
 type UserIDRequest struct {
@@ -19,7 +19,29 @@ type StandardResponse struct {
     Data    interface{} `json:"data,omitempty"`
 }
 
+// Machine readable error codes for authentication failures
+const (
+    CodeTokenMissing           = "token_missing"
+    CodeTokenMalformed         = "token_malformed"
+    CodeTokenInvalid           = "token_invalid"
+    CodeTokenExpired           = "token_expired"
+    CodeTokenWrongIssuer       = "token_wrong_issuer"
+    CodeTokenWrongAudience     = "token_wrong_audience"
+    CodeTokenWrongType         = "token_wrong_type"
+    CodeTokenRevoked           = "token_revoked"
+    CodeTokenWrongPrincipal    = "token_wrong_principal"
+    CodeInsufficientScope      = "insufficient_scope"
+    CodeInsufficientRole       = "insufficient_role"
+    CodeInsufficientPermission = "insufficient_permission"
+    CodeWrongOrganization      = "wrong_organization"
+    CodeInsufficientOrgRole    = "insufficient_org_role"
+    CodeEmailNotVerified       = "email_not_verified"
+    CodeAccountDeleted         = "account_deleted"
+    CodeInteractiveRequired    = "interactive_session_required"
+)
+
 type ErrorDetail struct {
+    Code    string `json:"code,omitempty"`
     Message string `json:"message"`
     Note *string `json:"note,omitempty"`
 }
@@ -53,6 +75,18 @@ func (rb *ResponseBuilder) Error(httpStatus int, message string) {
     rb.ctx.JSON(httpStatus, response)
 }
 
+func (rb *ResponseBuilder) ErrorWithCode(httpStatus int, code string, message string) {
+    response := StandardResponse{
+        Status: StatusError,
+        StatusCode: httpStatus,
+        Error: &ErrorDetail{
+            Code:    code,
+            Message: message,
+        },
+    }
+    rb.ctx.JSON(httpStatus, response)
+}
+
 func (rb *ResponseBuilder) ValidationError(httpStatus int, message string, note string) {
     response := StandardResponse{
         Status: StatusFail,
@@ -0,0 +1,155 @@
+package dto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Base64URL is binary data carried as unpadded base64url, the encoding the
+// WebAuthn JSON serialization uses
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// WebAuthn options in the JSON form accepted by
+// PublicKeyCredential.parseCreationOptionsFromJSON and
+// parseRequestOptionsFromJSON (WebAuthn Level 3 section 5.1.8)
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CredentialCreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// WebAuthnRegistrationOptionsResponse starts a registration. The session ID
+// must be sent back with the authenticator's response.
+type WebAuthnRegistrationOptionsResponse struct {
+	SessionID uuid.UUID                 `json:"session_id"`
+	PublicKey CredentialCreationOptions `json:"publicKey"`
+}
+
+// WebAuthnLoginOptionsResponse starts an authentication
+type WebAuthnLoginOptionsResponse struct {
+	SessionID uuid.UUID                `json:"session_id"`
+	PublicKey CredentialRequestOptions `json:"publicKey"`
+}
+
+// RegistrationCredential is PublicKeyCredential.toJSON() after create()
+type RegistrationCredential struct {
+	ID       string `json:"id"`
+	Type     string `json:"type" binding:"required,eq=public-key"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON" binding:"required"`
+		AttestationObject Base64URL `json:"attestationObject" binding:"required"`
+		Transports        []string  `json:"transports,omitempty" binding:"omitempty,max=10,dive,max=20"`
+	} `json:"response"`
+}
+
+// AssertionCredential is PublicKeyCredential.toJSON() after get()
+type AssertionCredential struct {
+	RawID    Base64URL `json:"rawId" binding:"required"`
+	Type     string    `json:"type" binding:"required,eq=public-key"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON" binding:"required"`
+		AuthenticatorData Base64URL `json:"authenticatorData" binding:"required"`
+		Signature         Base64URL `json:"signature" binding:"required"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type WebAuthnRegisterRequest struct {
+	SessionID  uuid.UUID              `json:"session_id" binding:"required"`
+	Name       string                 `json:"name" binding:"required,min=1,max=100"`
+	Credential RegistrationCredential `json:"credential"`
+}
+
+type WebAuthnLoginRequest struct {
+	SessionID  uuid.UUID           `json:"session_id" binding:"required"`
+	Credential AssertionCredential `json:"credential"`
+	DeviceName string              `json:"device_name,omitempty" binding:"omitempty,max=100"`
+}
+
+type WebAuthnMFAOptionsRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type WebAuthnMFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	WebAuthnLoginRequest
+}
+
+type WebAuthnCredentialResponse struct {
+	ID                uuid.UUID  `json:"id"`
+	Name              string     `json:"name"`
+	Transports        []string   `json:"transports,omitempty"`
+	AttestationFormat string     `json:"attestation_format"`
+	BackedUp          bool       `json:"backed_up"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// RegisteredCredentialResponse is a new passkey, with the recovery codes
+// generated when it is the user's first second factor
+type RegisteredCredentialResponse struct {
+	WebAuthnCredentialResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
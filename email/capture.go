@@ -0,0 +1,79 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+)
+
+// MemorySender keeps messages in memory instead of delivering them, so tests
+// can assert on what was sent
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemorySender creates an empty in-memory sender
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+func (s *MemorySender) Send(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, *msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Reset forgets the messages sent so far
+func (s *MemorySender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+// FileSender writes every message as an .eml file to the capture dir, which
+// mail clients can open during development
+type FileSender struct {
+	cfg *config.EmailConfig
+}
+
+// NewFileSender creates a sender that writes to the configured capture dir
+func NewFileSender(cfg *config.EmailConfig) *FileSender {
+	return &FileSender{cfg: cfg}
+}
+
+func (s *FileSender) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMessage(&s.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.cfg.CaptureDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create capture dir: %w", err)
+	}
+
+	// Timestamped names keep the files in the order they were sent
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to name captured message: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	if err := os.WriteFile(filepath.Join(s.cfg.CaptureDir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write captured message: %w", err)
+	}
+	return nil
+}
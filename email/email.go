@@ -0,0 +1,129 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/HersheyPlus/go-auth/config"
+)
+
+// Message is a single outgoing email. The JSON form is stored in the outbox.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"` // optional alternative to Text
+
+	// Template, when set, is rendered with Data in the Locale into Subject,
+	// Text and HTML before delivery
+	Template string      `json:"template,omitempty"`
+	Locale   string      `json:"locale,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// Sender delivers email messages
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// LogSender logs that a message would have been sent instead of delivering
+// it. It is used while email is disabled. Bodies carry reset tokens, login
+// codes and links, so they are left out, MemorySender and FileSender capture
+// them for development.
+type LogSender struct {
+	logger *log.Logger
+}
+
+// NewLogSender creates a sender that only logs messages
+func NewLogSender() *LogSender {
+	return &LogSender{logger: log.New(log.Writer(), "Email: ", log.LstdFlags)}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg *Message) error {
+	s.logger.Printf("To: %s, Subject: %s, Template: %s", msg.To, msg.Subject, msg.Template)
+	return nil
+}
+
+// NewSender creates the sender the email config asks for, rendering templates
+// from the built-in set and the templates dir
+func NewSender(cfg *config.EmailConfig) (Sender, error) {
+	templates, err := LoadTemplates(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.Enabled {
+		return WithTemplates(NewLogSender(), templates), nil
+	}
+	switch cfg.Transport {
+	case "smtp":
+		return WithTemplates(NewSMTPSender(cfg), templates), nil
+	case "file":
+		return WithTemplates(NewFileSender(cfg), templates), nil
+	default:
+		return nil, fmt.Errorf("unsupported email transport: %s", cfg.Transport)
+	}
+}
+
+// instance
+var (
+	defaultMu     sync.RWMutex
+	defaultSender Sender
+)
+
+// Default returns the sender installed by Setup, or one that logs messages
+// rendered from the built-in templates
+func Default() Sender {
+	defaultMu.RLock()
+	sender := defaultSender
+	defaultMu.RUnlock()
+	if sender != nil {
+		return sender
+	}
+	return WithTemplates(NewLogSender(), builtinTemplates())
+}
+
+// SetDefault replaces the sender returned by Default
+func SetDefault(sender Sender) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultSender = sender
+}
+
+// Setup creates the sender from the email config and installs it as default
+func Setup(cfg *config.EmailConfig) error {
+	sender, err := NewSender(cfg)
+	if err != nil {
+		return err
+	}
+	SetDefault(sender)
+	return nil
+}
+
+// templateSender renders template messages before handing them on
+type templateSender struct {
+	next      Sender
+	templates *Templates
+}
+
+// WithTemplates returns a sender that renders template messages with the
+// given templates and delivers them through next
+func WithTemplates(next Sender, templates *Templates) Sender {
+	return &templateSender{next: next, templates: templates}
+}
+
+func (s *templateSender) Send(ctx context.Context, msg *Message) error {
+	if msg.Template == "" {
+		return s.next.Send(ctx, msg)
+	}
+
+	rendered, err := s.templates.Render(msg.Template, msg.Locale, msg.Data)
+	if err != nil {
+		return err
+	}
+	rendered.To = msg.To
+	rendered.Template = msg.Template
+	return s.next.Send(ctx, rendered)
+}
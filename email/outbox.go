@@ -0,0 +1,21 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/HersheyPlus/go-auth/models"
+)
+
+// DeliverOutbox returns the outbox handler for email entries, whose payload is
+// a Message encoded as JSON
+func DeliverOutbox(sender Sender) func(ctx context.Context, msg *models.OutboxMessage) error {
+	return func(ctx context.Context, entry *models.OutboxMessage) error {
+		var msg Message
+		if err := json.Unmarshal([]byte(entry.Payload), &msg); err != nil {
+			return fmt.Errorf("failed to decode email: %w", err)
+		}
+		return sender.Send(ctx, &msg)
+	}
+}
@@ -0,0 +1,182 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+var ErrTemplateNotFound = errors.New("email template not found")
+
+//go:embed templates
+var builtinFS embed.FS
+
+// Templates renders messages from <name>.txt and optional <name>.html files.
+// The text template defines the subject with {{define "subject"}}. Locale
+// variants live in subdirectories named after the locale, e.g. de/ or pt-BR/.
+type Templates struct {
+	// keyed by lower case locale, "" for the default, then template name
+	sets map[string]map[string]*templateSet
+}
+
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var (
+	builtinOnce sync.Once
+	builtin     *Templates
+)
+
+// builtinTemplates returns the templates shipped with the binary
+func builtinTemplates() *Templates {
+	builtinOnce.Do(func() {
+		templates, err := LoadTemplates("")
+		if err != nil {
+			panic(fmt.Sprintf("email: invalid built-in templates: %v", err))
+		}
+		builtin = templates
+	})
+	return builtin
+}
+
+// LoadTemplates loads the built-in templates and then those in dir, which
+// replace built-in files of the same name. A missing dir is ignored.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{sets: make(map[string]map[string]*templateSet)}
+
+	root, err := fs.Sub(builtinFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.load(root); err != nil {
+		return nil, fmt.Errorf("failed to load built-in email templates: %w", err)
+	}
+
+	if dir == "" {
+		return t, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to read email templates dir: %w", err)
+	}
+	if err := t.load(os.DirFS(dir)); err != nil {
+		return nil, fmt.Errorf("failed to load email templates from %s: %w", dir, err)
+	}
+	return t, nil
+}
+
+// load parses the templates in the root of fsys and one level of locale
+// subdirectories
+func (t *Templates) load(fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.Count(name, "/") > 0 {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		locale, file := path.Split(name)
+		locale = strings.ToLower(strings.TrimSuffix(locale, "/"))
+		ext := path.Ext(file)
+		if ext != ".txt" && ext != ".html" {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		set := t.set(locale, strings.TrimSuffix(file, ext))
+		if ext == ".txt" {
+			tmpl, err := texttemplate.New(file).Parse(string(content))
+			if err != nil {
+				return err
+			}
+			if tmpl.Lookup("subject") == nil {
+				return fmt.Errorf("%s does not define a subject", name)
+			}
+			set.text = tmpl
+			return nil
+		}
+		tmpl, err := htmltemplate.New(file).Parse(string(content))
+		if err != nil {
+			return err
+		}
+		set.html = tmpl
+		return nil
+	})
+}
+
+func (t *Templates) set(locale string, name string) *templateSet {
+	sets, ok := t.sets[locale]
+	if !ok {
+		sets = make(map[string]*templateSet)
+		t.sets[locale] = sets
+	}
+	set, ok := sets[name]
+	if !ok {
+		set = &templateSet{}
+		sets[name] = set
+	}
+	return set
+}
+
+// Render executes the named template in the most specific variant there is
+// for the locale: "pt-BR" tries pt-BR, then pt, then the default templates
+func (t *Templates) Render(name string, locale string, data interface{}) (*Message, error) {
+	for _, candidate := range localeFallbacks(locale) {
+		set, ok := t.sets[candidate][name]
+		if !ok || set.text == nil {
+			continue
+		}
+
+		var subject, text bytes.Buffer
+		if err := set.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+			return nil, fmt.Errorf("failed to render subject of %s: %w", name, err)
+		}
+		if err := set.text.Execute(&text, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		msg := &Message{Subject: strings.TrimSpace(subject.String()), Text: text.String()}
+
+		if set.html != nil {
+			var html bytes.Buffer
+			if err := set.html.Execute(&html, data); err != nil {
+				return nil, fmt.Errorf("failed to render html of %s: %w", name, err)
+			}
+			msg.HTML = html.String()
+		}
+		return msg, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// localeFallbacks lists the locales to try for a requested one, most
+// specific first and ending with the default
+func localeFallbacks(locale string) []string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		return []string{""}
+	}
+	fallbacks := []string{locale}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		fallbacks = append(fallbacks, locale[:i])
+	}
+	return append(fallbacks, "")
+}
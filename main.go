@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
+	"github.com/HersheyPlus/go-auth/commands"
 	"github.com/HersheyPlus/go-auth/config"
 	"github.com/HersheyPlus/go-auth/database"
+	"github.com/HersheyPlus/go-auth/email"
 	"github.com/HersheyPlus/go-auth/server"
+	"github.com/HersheyPlus/go-auth/utils"
 )
 
 func main() {
@@ -17,9 +22,42 @@ func main() {
         log.Fatalf("Failed to connect to database: %v", err)
     }
     defer database.CloseDB()
+
+	if err := email.Setup(&cfg.Email); err != nil {
+        log.Fatalf("Failed to set up email: %v", err)
+    }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := utils.LoadKeyrings(ctx, database.GetDB(), &cfg.JWT); err != nil {
+        log.Fatalf("Failed to load signing keys: %v", err)
+    }
+	if err := utils.NewRBACStore(database.GetDB()).SeedDefaults(ctx); err != nil {
+        log.Fatalf("Failed to seed default roles: %v", err)
+    }
+
+	// Administrative commands, e.g. `go-auth keys promote <kid>`
+	if len(os.Args) > 1 {
+		if err := commands.Run(cfg, database.GetDB(), os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go utils.WatchKeyrings(ctx, database.GetDB(), cfg.JWT.KeyringReloadInterval)
+
+	outbox := utils.NewOutboxDispatcher(database.GetDB(), &cfg.Outbox)
+	outbox.Handle(utils.OutboxTopicEmail, email.DeliverOutbox(email.Default()))
+	outbox.Handle(utils.OutboxTopicEvent, utils.NewWebhookHandler(&cfg.Outbox.Webhook))
+	go outbox.Run(ctx)
+
+	if cfg.Features.EnableUserDeletion {
+		go utils.NewAccountStore(database.GetDB(), &cfg.Security.AccountDeletion).RunPurge(ctx)
+	}
+
 	server := server.NewServer(cfg)
 	if err := server.RunServer(); err != nil {
 		log.Fatalf("Failed to run server: %v", err)
 	}
     
-}
\ No newline at end of file
+}
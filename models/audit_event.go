@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a change a user made to their account
+type AuditEvent struct {
+	ID        uuid.UUID              `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID              `gorm:"type:uuid;not null;index"`
+	Action    string                 `gorm:"type:varchar(100);not null;index"`
+	Details   map[string]interface{} `gorm:"type:text;serializer:json"`
+	IPAddress string                 `gorm:"type:varchar(45)"`
+	UserAgent string                 `gorm:"type:varchar(255)"`
+	CreatedAt time.Time              `gorm:"not null;default:current_timestamp;index"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// EmailLoginChallenge is a pending passwordless login. The emailed code and
+// the magic link both redeem it, and only once.
+type EmailLoginChallenge struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID   uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash string    `gorm:"type:varchar(255);not null"`
+	// Attempts counts wrong codes, the challenge is spent when it reaches the limit
+	Attempts  int       `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (EmailLoginChallenge) TableName() string {
+	return "email_login_challenges"
+}
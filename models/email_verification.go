@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// EmailVerificationToken is an emailed, single-use token proving the user
+// receives mail at Email. Only a hash of the token is stored.
+type EmailVerificationToken struct {
+	ID     uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	// Email is the address the token was sent to, it only verifies that one
+	Email     string    `gorm:"type:varchar(100);not null"`
+	TokenHash string    `gorm:"type:varchar(64);not null;unique"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (EmailVerificationToken) TableName() string {
+	return "email_verification_tokens"
+}
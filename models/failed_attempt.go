@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// FailedAttempt counts a user's failed attempts of a kind that are not tied to
+// a single row, such as recovery codes or emailed login codes, so that the
+// count survives the rows being replaced
+type FailedAttempt struct {
+	UserID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Kind        string    `gorm:"type:varchar(50);primary_key"`
+	Count       int       `gorm:"not null;default:0"`
+	LockedUntil *time.Time
+	UpdatedAt   time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (FailedAttempt) TableName() string {
+	return "failed_attempts"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// TOTPAuthenticator is a user's authenticator app enrollment. It only guards
+// logins once ConfirmedAt is set by a first valid code.
+type TOTPAuthenticator struct {
+	UserID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Secret      string    `gorm:"type:varchar(64);not null"` // base32, as shown to the user
+	ConfirmedAt *time.Time
+	// LastUsedStep is the last accepted time step, codes of earlier steps are replays
+	LastUsedStep   int64 `gorm:"not null;default:0"`
+	FailedAttempts int   `gorm:"not null;default:0"`
+	LockedUntil    *time.Time
+	CreatedAt      time.Time `gorm:"not null;default:current_timestamp"`
+	UpdatedAt      time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (TOTPAuthenticator) TableName() string {
+	return "totp_authenticators"
+}
+
+// RecoveryCode is a single-use code that replaces the authenticator when the
+// user has lost it. Codes are hashed like passwords.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"type:varchar(255);not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
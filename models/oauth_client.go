@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// OAuthClient is a registered client allowed to call the OAuth endpoints.
+// Public clients (SPAs, mobile apps) have no secret and must use PKCE.
+type OAuthClient struct {
+	ClientID      string    `gorm:"type:varchar(100);primary_key"`
+	SecretHash    string    `gorm:"type:varchar(255);not null"`
+	Name          string    `gorm:"type:varchar(100);not null"`
+	Confidential  bool      `gorm:"not null;default:true"`
+	RedirectURIs  []string  `gorm:"type:text;serializer:json"`
+	AllowedGrants []string  `gorm:"type:text;serializer:json"`
+	Scopes        []string  `gorm:"type:text;serializer:json"`
+	CreatedAt     time.Time `gorm:"not null;default:current_timestamp"`
+	UpdatedAt     time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// AuthorizationCode is a single-use code issued by /oauth/authorize. Only a
+// hash of the code is stored.
+type AuthorizationCode struct {
+	CodeHash            string     `gorm:"type:varchar(64);primary_key"`
+	ClientID            string     `gorm:"type:varchar(100);not null;index"`
+	UserID              uuid.UUID  `gorm:"type:uuid;not null;index"`
+	RedirectURI         string     `gorm:"type:text;not null"`
+	Scope               string     `gorm:"type:text"`
+	CodeChallenge       string     `gorm:"type:varchar(128)"`
+	CodeChallengeMethod string     `gorm:"type:varchar(10)"`
+	Nonce               string     `gorm:"type:varchar(255)"`
+	ExpiresAt           time.Time  `gorm:"not null;index"`
+	ConsumedAt          *time.Time
+	// SessionID is the session created when the code was exchanged, revoked if the code is replayed
+	SessionID *uuid.UUID `gorm:"type:uuid"`
+	CreatedAt time.Time  `gorm:"not null;default:current_timestamp"`
+}
+
+func (AuthorizationCode) TableName() string {
+	return "authorization_codes"
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// Organization is a tenant that users belong to through memberships
+type Organization struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string    `gorm:"type:varchar(100);not null"`
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+	UpdatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+// Membership places a user in an organization with a per-organization role
+type Membership struct {
+	OrganizationID uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID         uuid.UUID `gorm:"type:uuid;primary_key;index"`
+	Role           string    `gorm:"type:varchar(20);not null"`
+	CreatedAt      time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (Membership) TableName() string {
+	return "memberships"
+}
+
+// Invitation asks the owner of an email address to join an organization. Only
+// a hash of the acceptance token is stored.
+type Invitation struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrganizationID uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Email          string     `gorm:"type:varchar(100);not null;index"`
+	Role           string     `gorm:"type:varchar(20);not null"`
+	TokenHash      string     `gorm:"type:varchar(64);not null;unique"`
+	InvitedBy      uuid.UUID  `gorm:"type:uuid;not null"`
+	ExpiresAt      time.Time  `gorm:"not null;index"`
+	AcceptedAt     *time.Time
+	CreatedAt      time.Time  `gorm:"not null;default:current_timestamp"`
+}
+
+func (Invitation) TableName() string {
+	return "invitations"
+}
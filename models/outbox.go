@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// OutboxMessage is a notification written in the same transaction as the
+// change it reports and delivered by the outbox dispatcher. Delivered entries
+// are deleted, entries that keep failing are dead-lettered with DeadAt and
+// their emails Redacted, until the dead letter retention purges them.
+type OutboxMessage struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Topic         string     `gorm:"type:varchar(100);not null"`
+	Payload       string     `gorm:"type:text;not null"` // JSON
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index"`
+	LastError     string     `gorm:"type:text"`
+	DeadAt        *time.Time `gorm:"index"`
+	Redacted      bool       `gorm:"not null;default:false"`
+	CreatedAt     time.Time  `gorm:"not null;default:current_timestamp"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
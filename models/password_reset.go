@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// PasswordResetToken is an emailed, single-use token allowing a user to set a
+// new password. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"type:varchar(64);not null;unique"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
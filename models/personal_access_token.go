@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// PersonalAccessToken is a long-lived token a user creates for scripts and CI.
+// Only a hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Name       string     `gorm:"type:varchar(100);not null"`
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"`
+	// TokenHint is the end of the token, shown so users can tell tokens apart
+	TokenHint  string     `gorm:"type:varchar(10);not null"`
+	Scopes     []string   `gorm:"type:text;serializer:json"`
+	ExpiresAt  *time.Time `gorm:"index"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time  `gorm:"not null;default:current_timestamp"`
+}
+
+func (PersonalAccessToken) TableName() string {
+	return "personal_access_tokens"
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// Role is a named set of permissions assigned to users
+type Role struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string    `gorm:"type:varchar(50);not null;unique"`
+	Description string    `gorm:"type:varchar(255)"`
+	CreatedAt   time.Time `gorm:"not null;default:current_timestamp"`
+	UpdatedAt   time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// Permission is a single right, named like a scope (e.g. "users:read")
+type Permission struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string    `gorm:"type:varchar(100);not null;unique"`
+	Description string    `gorm:"type:varchar(255)"`
+	CreatedAt   time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	RoleID       uuid.UUID `gorm:"type:uuid;primary_key"`
+	PermissionID uuid.UUID `gorm:"type:uuid;primary_key;index"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// UserRole assigns a role to a user
+type UserRole struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primary_key"`
+	RoleID    uuid.UUID `gorm:"type:uuid;primary_key;index"`
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
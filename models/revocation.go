@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// RevokedToken is a denylisted access token, kept until the token expires
+type RevokedToken struct {
+	JTI       string    `gorm:"type:varchar(100);primary_key"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// TokenCutoff revokes every token of a user issued before RevokedBefore
+type TokenCutoff struct {
+	UserID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	RevokedBefore time.Time `gorm:"not null"`
+	UpdatedAt     time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (TokenCutoff) TableName() string {
+	return "token_cutoffs"
+}
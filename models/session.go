@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// Session represents a single login on one device. Every refresh token issued
+// by rotation from that login shares the session ID as its FamilyID.
+type Session struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	DeviceName string    `gorm:"type:varchar(100)"`
+	UserAgent  string    `gorm:"type:varchar(255)"`
+	IPAddress  string    `gorm:"type:varchar(45)"`
+	CreatedAt  time.Time `gorm:"not null;default:current_timestamp;index"`
+	LastUsedAt time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// SigningKey is a JWT signing key in the keyring. KeyMaterial holds the PEM
+// encoded private key, or the shared secret for HMAC keys, so access to this
+// table must be restricted like any other secret store.
+type SigningKey struct {
+	KID         string     `gorm:"type:varchar(100);primary_key"`
+	Purpose     string     `gorm:"type:varchar(20);not null;index"` // "access" or "refresh"
+	Algorithm   string     `gorm:"type:varchar(10);not null"`
+	KeyMaterial string     `gorm:"type:text;not null"`
+	Status      string     `gorm:"type:varchar(20);not null;index"` // "active" or "verify"
+	ActivatedAt *time.Time
+	RetireAfter *time.Time
+	CreatedAt   time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
@@ -13,6 +13,8 @@ type User struct {
     Phone     string  `gorm:"type:varchar(20);not null" json:"phone"`
     Email     string  `gorm:"type:varchar(100);unique;not null;index" json:"email"`
     Password  string  `gorm:"type:varchar(255);not null" json:"-"`
+    // EmailVerifiedAt is set once the user proved they receive mail at Email
+    EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
     RefreshTokens []RefreshToken `gorm:"foreignKey:UserID"`
 }
 
@@ -24,6 +26,10 @@ type RefreshToken struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
 	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
 	TokenUUID string    `gorm:"type:varchar(100);not null;unique;index"`
+	// FamilyID groups every token issued by rotation from a single login (Session.ID)
+	FamilyID   uuid.UUID  `gorm:"type:uuid;not null;default:gen_random_uuid();index"`
+	ReplacedBy string     `gorm:"type:varchar(100)"`
+	RevokedAt  *time.Time `gorm:"index"`
 	ExpiresAt time.Time `gorm:"not null;index"`
 	CreatedAt time.Time `gorm:"not null;default:current_timestamp"`
 	UpdatedAt time.Time `gorm:"not null;default:current_timestamp"`
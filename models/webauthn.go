@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+	"github.com/google/uuid"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user
+type WebAuthnCredential struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index"`
+	Name         string    `gorm:"type:varchar(100);not null"`
+	CredentialID []byte    `gorm:"type:bytea;not null;uniqueIndex"`
+	// PublicKey is the credential public key in COSE_Key format
+	PublicKey         []byte    `gorm:"type:bytea;not null"`
+	SignCount         int64     `gorm:"not null;default:0"`
+	Transports        []string  `gorm:"type:text;serializer:json"`
+	AttestationFormat string    `gorm:"type:varchar(32);not null"`
+	AAGUID            uuid.UUID `gorm:"type:uuid"`
+	// BackedUp is set for passkeys synced between devices
+	BackedUp   bool `gorm:"not null;default:false"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnSession holds the challenge of a registration or authentication
+// ceremony in progress. Each session can be finished once.
+type WebAuthnSession struct {
+	ID uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	// UserID is empty for passwordless logins, where the credential names the user
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Ceremony  string     `gorm:"type:varchar(20);not null"`
+	Challenge string     `gorm:"type:varchar(64);not null"`
+	ExpiresAt time.Time  `gorm:"not null;index"`
+	CreatedAt time.Time  `gorm:"not null;default:current_timestamp"`
+}
+
+func (WebAuthnSession) TableName() string {
+	return "webauthn_sessions"
+}
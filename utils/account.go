@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// purgeBatchSize limits how many accounts a purge run loads at once
+const purgeBatchSize = 100
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// userData holds the rows a user owns besides memberships, purged with the
+// account. Denylisted access tokens are not keyed by user and expire on their
+// own.
+var userData = []interface{}{
+	&models.RefreshToken{},
+	&models.Session{},
+	&models.PersonalAccessToken{},
+	&models.AuthorizationCode{},
+	&models.TOTPAuthenticator{},
+	&models.RecoveryCode{},
+	&models.WebAuthnCredential{},
+	&models.WebAuthnSession{},
+	&models.EmailLoginChallenge{},
+	&models.FailedAttempt{},
+	&models.TokenCutoff{},
+	&models.PasswordResetToken{},
+	&models.EmailVerificationToken{},
+	&models.UserRole{},
+	&models.AuditEvent{},
+}
+
+// AccountStore handles the deletion of accounts. Deleted accounts are soft
+// deleted and can be restored until the grace period ends, when they are
+// purged with their data.
+type AccountStore struct {
+	db  *gorm.DB
+	cfg *config.AccountDeletionConfig
+}
+
+// NewAccountStore creates a new account store instance
+func NewAccountStore(db *gorm.DB, cfg *config.AccountDeletionConfig) *AccountStore {
+	return &AccountStore{db: db, cfg: cfg}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *AccountStore) WithTx(tx *gorm.DB) *AccountStore {
+	return &AccountStore{db: tx, cfg: s.cfg}
+}
+
+// PurgeAt returns when a deleted account is purged
+func (s *AccountStore) PurgeAt(user *models.User) time.Time {
+	return user.DeletedAt.Time.Add(s.cfg.GracePeriod)
+}
+
+// DeleteAccount soft-deletes the user. It fails with ErrLastOwner when the
+// user is the only owner of an organization that has other members, who would
+// be left without one. The organizations are locked so that no owner can
+// leave or be demoted between the check and the deletion.
+func (s *AccountStore) DeleteAccount(ctx context.Context, userID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var orgIDs []uuid.UUID
+		if err := tx.Model(&models.Organization{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN (SELECT organization_id FROM memberships WHERE user_id = ? AND role = ?)", userID, OrgRoleOwner).
+			Order("id").
+			Pluck("id", &orgIDs).Error; err != nil {
+			return fmt.Errorf("failed to lock organizations: %w", err)
+		}
+
+		if len(orgIDs) > 0 {
+			var owned int64
+			if err := tx.Model(&models.Membership{}).
+				Where("user_id = ? AND role = ? AND organization_id IN ?", userID, OrgRoleOwner, orgIDs).
+				Where("NOT EXISTS (SELECT 1 FROM memberships o WHERE o.organization_id = memberships.organization_id AND o.user_id <> memberships.user_id AND o.role = ?)", OrgRoleOwner).
+				Where("EXISTS (SELECT 1 FROM memberships o WHERE o.organization_id = memberships.organization_id AND o.user_id <> memberships.user_id)").
+				Count(&owned).Error; err != nil {
+				return fmt.Errorf("failed to check organization ownership: %w", err)
+			}
+			if owned > 0 {
+				return ErrLastOwner
+			}
+		}
+
+		result := tx.Where("user_id = ?", userID).Delete(&models.User{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete account: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAccountNotFound
+		}
+		return nil
+	})
+}
+
+// FindDeleted returns the deleted account with the email address while it
+// can still be restored
+func (s *AccountStore) FindDeleted(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	err := s.db.WithContext(ctx).Unscoped().
+		Where("email = ? AND deleted_at > ?", strings.ToLower(email), time.Now().Add(-s.cfg.GracePeriod)).
+		First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("failed to load deleted account: %w", err)
+	}
+	return &user, nil
+}
+
+// RestoreAccount undeletes an account within its grace period
+func (s *AccountStore) RestoreAccount(ctx context.Context, userID uuid.UUID) error {
+	result := s.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("user_id = ? AND deleted_at > ?", userID, time.Now().Add(-s.cfg.GracePeriod)).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore account: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+// Purge hard-deletes the accounts past their grace period and returns how
+// many were purged
+func (s *AccountStore) Purge(ctx context.Context) (int, error) {
+	purged := 0
+	for ctx.Err() == nil {
+		var ids []uuid.UUID
+		if err := s.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+			Where("deleted_at <= ?", time.Now().Add(-s.cfg.GracePeriod)).
+			Order("deleted_at").
+			Limit(purgeBatchSize).
+			Pluck("user_id", &ids).Error; err != nil {
+			return purged, fmt.Errorf("failed to list accounts to purge: %w", err)
+		}
+
+		progress := false
+		for _, id := range ids {
+			ok, err := s.purgeAccount(ctx, id)
+			if err != nil {
+				return purged, err
+			}
+			if ok {
+				purged++
+				progress = true
+			}
+		}
+		// A full batch of accounts being purged elsewhere would be listed again
+		if len(ids) < purgeBatchSize || !progress {
+			break
+		}
+	}
+	return purged, nil
+}
+
+// purgeAccount deletes an account and everything it owns, and reports whether
+// it did. Accounts restored meanwhile or being purged elsewhere are skipped.
+func (s *AccountStore) purgeAccount(ctx context.Context, userID uuid.UUID) (bool, error) {
+	purged := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("user_id = ? AND deleted_at <= ?", userID, time.Now().Add(-s.cfg.GracePeriod)).
+			Limit(1).Find(&user).Error; err != nil {
+			return fmt.Errorf("failed to lock account: %w", err)
+		}
+		if user.UserID == uuid.Nil {
+			return nil
+		}
+
+		for _, model := range userData {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to purge %T: %w", model, err)
+			}
+		}
+
+		// Organizations the user was the last member of go with the account
+		var orgIDs []uuid.UUID
+		if err := tx.Model(&models.Membership{}).Where("user_id = ?", userID).Pluck("organization_id", &orgIDs).Error; err != nil {
+			return fmt.Errorf("failed to list memberships: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Membership{}).Error; err != nil {
+			return fmt.Errorf("failed to purge memberships: %w", err)
+		}
+		if len(orgIDs) > 0 {
+			var emptyIDs []uuid.UUID
+			if err := tx.Model(&models.Organization{}).
+				Where("id IN ? AND NOT EXISTS (SELECT 1 FROM memberships m WHERE m.organization_id = organizations.id)", orgIDs).
+				Pluck("id", &emptyIDs).Error; err != nil {
+				return fmt.Errorf("failed to list empty organizations: %w", err)
+			}
+			if len(emptyIDs) > 0 {
+				if err := tx.Where("organization_id IN ?", emptyIDs).Delete(&models.Invitation{}).Error; err != nil {
+					return fmt.Errorf("failed to purge invitations: %w", err)
+				}
+				if err := tx.Where("id IN ?", emptyIDs).Delete(&models.Organization{}).Error; err != nil {
+					return fmt.Errorf("failed to purge organizations: %w", err)
+				}
+			}
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.User{}).Error; err != nil {
+			return fmt.Errorf("failed to purge account: %w", err)
+		}
+		if err := NewOutboxStore(tx).EnqueueEvent(ctx, EventAccountPurged, userID, nil); err != nil {
+			return err
+		}
+		purged = true
+		return nil
+	})
+	return purged, err
+}
+
+// RunPurge purges expired accounts at every purge interval until ctx is done
+func (s *AccountStore) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.Purge(ctx)
+			if err != nil {
+				log.Printf("Failed to purge deleted accounts: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted accounts", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of failed attempts counted per user
+const (
+	attemptRecoveryCode = "recovery_code"
+	attemptEmailLogin   = "email_login"
+)
+
+// attemptLimiter locks a kind of attempt for a user after too many failures in
+// a row. Its methods run in the caller's transaction.
+type attemptLimiter struct {
+	kind        string
+	maxAttempts int
+	lockout     time.Duration
+}
+
+// lock loads the user's counter for update, creating it when missing, and
+// reports whether the user is locked out
+func (l attemptLimiter) lock(tx *gorm.DB, userID uuid.UUID) (*models.FailedAttempt, bool, error) {
+	attempt := models.FailedAttempt{UserID: userID, Kind: l.kind, UpdatedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&attempt).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to create attempt counter: %w", err)
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&attempt, "user_id = ? AND kind = ?", userID, l.kind).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to load attempt counter: %w", err)
+	}
+	locked := attempt.LockedUntil != nil && time.Now().Before(*attempt.LockedUntil)
+	return &attempt, locked, nil
+}
+
+// record counts the outcome of an attempt. A success resets the counter, and
+// the failure that reaches the limit starts the lockout.
+func (l attemptLimiter) record(tx *gorm.DB, attempt *models.FailedAttempt, ok bool) error {
+	updates := map[string]interface{}{"count": 0, "locked_until": nil, "updated_at": time.Now()}
+	if !ok {
+		updates["count"] = attempt.Count + 1
+		if attempt.Count+1 >= l.maxAttempts {
+			updates["count"] = 0
+			updates["locked_until"] = time.Now().Add(l.lockout)
+		}
+	}
+	if err := tx.Model(&models.FailedAttempt{}).
+		Where("user_id = ? AND kind = ?", attempt.UserID, attempt.Kind).
+		Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update attempt counter: %w", err)
+	}
+	return nil
+}
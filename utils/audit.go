@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"gorm.io/gorm"
+)
+
+// Audited account actions
+const (
+	AuditProfileUpdated  = "profile.updated"
+	AuditEmailChanged    = "email.changed"
+	AuditAccountDeleted  = "account.deleted"
+	AuditAccountRestored = "account.restored"
+)
+
+// AuditStore records audit events
+type AuditStore struct {
+	db *gorm.DB
+}
+
+// NewAuditStore creates a new audit store instance
+func NewAuditStore(db *gorm.DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+// WithTx returns a store that records events in the given transaction, so
+// they are only kept if the audited change commits
+func (s *AuditStore) WithTx(tx *gorm.DB) *AuditStore {
+	return &AuditStore{db: tx}
+}
+
+// Record stores an audit event
+func (s *AuditStore) Record(ctx context.Context, event models.AuditEvent) error {
+	event.IPAddress = truncate(event.IPAddress, 45)
+	event.UserAgent = truncate(event.UserAgent, 255)
+	if err := s.db.WithContext(ctx).Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
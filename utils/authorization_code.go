@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PKCEMethodS256 is the only supported PKCE code challenge method
+const PKCEMethodS256 = "S256"
+
+var (
+	ErrInvalidAuthorizationCode = errors.New("authorization code is invalid or expired")
+	ErrAuthorizationCodeReused  = errors.New("authorization code has already been used")
+)
+
+// CodeStore handles authorization code operations
+type CodeStore struct {
+	db *gorm.DB
+}
+
+// NewCodeStore creates a new authorization code store instance
+func NewCodeStore(db *gorm.DB) *CodeStore {
+	return &CodeStore{db: db}
+}
+
+// CreateCode stores the authorization and returns the plain code
+func (s *CodeStore) CreateCode(ctx context.Context, authorization models.AuthorizationCode) (string, error) {
+	code, err := GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	authorization.CodeHash = HashSecret(code)
+	if err := s.db.WithContext(ctx).Create(&authorization).Error; err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// ConsumeCode marks the code as used by the session about to be created and
+// returns the authorization. Replaying a used code revokes the session created
+// by its first use and returns ErrAuthorizationCodeReused.
+func (s *CodeStore) ConsumeCode(ctx context.Context, code string, clientID string, redirectURI string, sessionID uuid.UUID) (*models.AuthorizationCode, error) {
+	var authorization models.AuthorizationCode
+	reused := false
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ?", HashSecret(code)).
+			First(&authorization).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvalidAuthorizationCode
+			}
+			return fmt.Errorf("failed to load authorization code: %w", err)
+		}
+
+		if authorization.ClientID != clientID {
+			return ErrInvalidAuthorizationCode
+		}
+
+		if authorization.ConsumedAt != nil {
+			// Commit the revocation and report the replay afterwards
+			reused = true
+			if authorization.SessionID == nil {
+				return nil
+			}
+			return deleteSessions(tx, []uuid.UUID{*authorization.SessionID})
+		}
+
+		if authorization.RedirectURI != redirectURI || !authorization.ExpiresAt.After(time.Now()) {
+			return ErrInvalidAuthorizationCode
+		}
+
+		now := time.Now()
+		return tx.Model(&authorization).Updates(map[string]interface{}{
+			"consumed_at": now,
+			"session_id":  sessionID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrAuthorizationCodeReused
+	}
+	return &authorization, nil
+}
+
+// CleanupExpiredCodes removes expired authorization codes
+func (s *CodeStore) CleanupExpiredCodes(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.AuthorizationCode{}).Error
+}
+
+// VerifyPKCE checks a code verifier against the stored S256 challenge
+// (RFC 7636 section 4.6)
+func VerifyPKCE(challenge string, method string, verifier string) bool {
+	if method != PKCEMethodS256 || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
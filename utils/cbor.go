@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR decoder (RFC 8949) for the structures WebAuthn uses: the
+// attestation object and COSE keys. Indefinite lengths, tags and half
+// precision floats never appear in them and are rejected.
+
+var ErrCBORMalformed = errors.New("malformed cbor")
+
+// maxCBORDepth bounds nesting so hostile input cannot exhaust the stack
+const maxCBORDepth = 16
+
+// decodeCBOR decodes a single item and returns it with the remaining input.
+// Unsigned and negative integers become int64, byte strings []byte, text
+// strings string, arrays []interface{} and maps map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, ErrCBORMalformed
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// Simple values and floats carry their own payload
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		case 26, 27:
+			size := 1 << (info - 24)
+			if len(data) < size {
+				return nil, nil, ErrCBORMalformed
+			}
+			if size == 4 {
+				return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[size:], nil
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), data[size:], nil
+		default:
+			return nil, nil, ErrCBORMalformed
+		}
+	}
+
+	arg, data, err := decodeCBORArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrCBORMalformed
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrCBORMalformed
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrCBORMalformed
+		}
+		if major == 2 {
+			return data[:arg], data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrCBORMalformed
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrCBORMalformed
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrCBORMalformed
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		// Tags are not used by WebAuthn structures
+		return nil, nil, ErrCBORMalformed
+	}
+}
+
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return 0, nil, ErrCBORMalformed
+		}
+		var value uint64
+		for _, b := range data[:size] {
+			value = value<<8 | uint64(b)
+		}
+		return value, data[size:], nil
+	default:
+		return 0, nil, ErrCBORMalformed
+	}
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"gorm.io/gorm"
+)
+
+// OAuth grant types a client can be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+var (
+	ErrInvalidClient = errors.New("invalid client credentials")
+	ErrInvalidScope  = errors.New("requested scope is not allowed for the client")
+)
+
+// ClientOptions describes a client to register
+type ClientOptions struct {
+	Name          string
+	Confidential  bool
+	RedirectURIs  []string
+	AllowedGrants []string
+	Scopes        []string
+}
+
+// ClientStore handles registered OAuth client operations
+type ClientStore struct {
+	db *gorm.DB
+}
+
+// NewClientStore creates a new client store instance
+func NewClientStore(db *gorm.DB) *ClientStore {
+	return &ClientStore{db: db}
+}
+
+// CreateClient registers a client and returns it with its plain secret, which
+// is only available at creation time. Public clients get no secret. A service
+// account is a confidential client allowed the client credentials grant.
+func (s *ClientStore) CreateClient(ctx context.Context, opts ClientOptions) (*models.OAuthClient, string, error) {
+	for _, grant := range opts.AllowedGrants {
+		switch grant {
+		case GrantAuthorizationCode, GrantRefreshToken:
+		case GrantClientCredentials:
+			if !opts.Confidential {
+				return nil, "", fmt.Errorf("public clients cannot use the %s grant", grant)
+			}
+		default:
+			return nil, "", fmt.Errorf("unsupported grant type: %s", grant)
+		}
+	}
+
+	suffix := make([]byte, 12)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, "", fmt.Errorf("failed to generate client id: %w", err)
+	}
+
+	client := &models.OAuthClient{
+		ClientID:      "client_" + hex.EncodeToString(suffix),
+		Name:          opts.Name,
+		Confidential:  opts.Confidential,
+		RedirectURIs:  opts.RedirectURIs,
+		AllowedGrants: opts.AllowedGrants,
+		Scopes:        opts.Scopes,
+	}
+
+	var secret string
+	if opts.Confidential {
+		var err error
+		if secret, err = GenerateSecret(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = HashSecret(secret)
+	}
+
+	if err := s.db.WithContext(ctx).Create(client).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, secret, nil
+}
+
+// GetClient loads a registered client
+func (s *ClientStore) GetClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := s.db.WithContext(ctx).First(&client, "client_id = ?", clientID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidClient
+		}
+		return nil, fmt.Errorf("failed to load client: %w", err)
+	}
+	return &client, nil
+}
+
+// Authenticate verifies client credentials
+func (s *ClientStore) Authenticate(ctx context.Context, clientID string, secret string) (*models.OAuthClient, error) {
+	client, err := s.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential || !CompareSecret(client.SecretHash, secret) {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// ListClients returns every registered client
+func (s *ClientStore) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	if err := s.db.WithContext(ctx).Order("created_at").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	return clients, nil
+}
+
+// DeleteClient removes a registered client
+func (s *ClientStore) DeleteClient(ctx context.Context, clientID string) error {
+	result := s.db.WithContext(ctx).Where("client_id = ?", clientID).Delete(&models.OAuthClient{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete client: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidClient
+	}
+	return nil
+}
+
+// ClientAllowsGrant reports whether the client may use the grant type
+func ClientAllowsGrant(client *models.OAuthClient, grant string) bool {
+	for _, allowed := range client.AllowedGrants {
+		if allowed == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientHasRedirectURI reports whether the redirect URI is registered. URIs
+// are compared exactly, as OAuth 2.0 security best practice requires.
+func ClientHasRedirectURI(client *models.OAuthClient, redirectURI string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveScope checks the requested space separated scopes against the
+// client's allowed scopes. An empty request grants every allowed scope.
+func ResolveScope(client *models.OAuthClient, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return strings.Join(client.Scopes, " "), nil
+	}
+
+	allowed := make(map[string]bool, len(client.Scopes))
+	for _, scope := range client.Scopes {
+		allowed[scope] = true
+	}
+	for _, scope := range scopes {
+		if !allowed[scope] {
+			return "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
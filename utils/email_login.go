@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmailLoginCodeDigits is the length of emailed login codes
+const EmailLoginCodeDigits = 6
+
+// emailResendInterval is the minimum time between two emails of a kind to a
+// user, so the public endpoints cannot be used to flood an inbox
+const emailResendInterval = time.Minute
+
+var (
+	ErrEmailLoginInvalid   = errors.New("invalid or expired login code")
+	ErrEmailLoginThrottled = errors.New("a login code was sent recently")
+)
+
+// EmailLoginStore handles the challenges of passwordless email logins
+type EmailLoginStore struct {
+	db  *gorm.DB
+	cfg *config.SecurityConfig
+
+	dummyOnce sync.Once
+	dummyHash []byte
+}
+
+// NewEmailLoginStore creates a new email login store instance
+func NewEmailLoginStore(db *gorm.DB, cfg *config.SecurityConfig) *EmailLoginStore {
+	return &EmailLoginStore{db: db, cfg: cfg}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *EmailLoginStore) WithTx(tx *gorm.DB) *EmailLoginStore {
+	return &EmailLoginStore{db: tx, cfg: s.cfg}
+}
+
+// NewEmailLoginCode generates a login code and its hash. Callers hash before
+// looking the user up, so unknown addresses take as long as known ones.
+func (s *EmailLoginStore) NewEmailLoginCode() (string, string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate login code: %w", err)
+	}
+	code := fmt.Sprintf("%0*d", EmailLoginCodeDigits, n.Int64())
+
+	hash, err := HashPassword(code, s.cfg)
+	if err != nil {
+		return "", "", err
+	}
+	return code, hash, nil
+}
+
+// Issue replaces the user's pending challenge with a new one for the hashed
+// code. ErrEmailLoginThrottled is returned while the last one is recent.
+func (s *EmailLoginStore) Issue(ctx context.Context, userID uuid.UUID, codeHash string) (*models.EmailLoginChallenge, error) {
+	var challenge *models.EmailLoginChallenge
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the user row so concurrent requests cannot both pass the check
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("user_id").First(&user, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var recent int64
+		if err := tx.Model(&models.EmailLoginChallenge{}).
+			Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-emailResendInterval)).
+			Count(&recent).Error; err != nil {
+			return fmt.Errorf("failed to check login codes: %w", err)
+		}
+		if recent > 0 {
+			return ErrEmailLoginThrottled
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.EmailLoginChallenge{}).Error; err != nil {
+			return fmt.Errorf("failed to delete login codes: %w", err)
+		}
+
+		challenge = &models.EmailLoginChallenge{
+			UserID:    userID,
+			CodeHash:  codeHash,
+			ExpiresAt: time.Now().Add(s.cfg.EmailLogin.CodeTTL),
+		}
+		if err := tx.Create(challenge).Error; err != nil {
+			return fmt.Errorf("failed to store login code: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// RedeemCode spends the user's pending challenge if the code matches. Wrong
+// codes count against the challenge, which is spent once they reach the limit.
+// They are also counted per user, so that requesting new codes does not buy
+// more guesses: after the limit email login is locked for a while, and every
+// code fails like a wrong one. An unknown user (uuid.Nil) fails the same way,
+// after the same work.
+func (s *EmailLoginStore) RedeemCode(ctx context.Context, userID uuid.UUID, code string) error {
+	limiter := attemptLimiter{kind: attemptEmailLogin, maxAttempts: s.cfg.EmailLogin.MaxAttempts, lockout: s.cfg.EmailLogin.LockoutDuration}
+
+	// Failed attempts must be committed, so the rejection is returned after
+	// the transaction instead of rolling it back
+	var rejected error
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var attempt *models.FailedAttempt
+		if userID != uuid.Nil {
+			var locked bool
+			var err error
+			if attempt, locked, err = limiter.lock(tx, userID); err != nil {
+				return err
+			}
+			if locked {
+				_ = bcrypt.CompareHashAndPassword(s.dummyCodeHash(), []byte(code))
+				rejected = ErrEmailLoginInvalid
+				return nil
+			}
+		}
+
+		var challenge models.EmailLoginChallenge
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, time.Now()).
+			Order("created_at DESC").
+			First(&challenge).Error
+		if err == gorm.ErrRecordNotFound {
+			_ = bcrypt.CompareHashAndPassword(s.dummyCodeHash(), []byte(code))
+			rejected = ErrEmailLoginInvalid
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load login code: %w", err)
+		}
+
+		now := time.Now()
+		ok := bcrypt.CompareHashAndPassword([]byte(challenge.CodeHash), []byte(code)) == nil
+		updates := map[string]interface{}{"used_at": now}
+		if !ok {
+			updates = map[string]interface{}{"attempts": challenge.Attempts + 1}
+			if challenge.Attempts+1 >= s.cfg.EmailLogin.MaxAttempts {
+				updates["used_at"] = now
+			}
+			rejected = ErrEmailLoginInvalid
+		}
+		if err := tx.Model(&challenge).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update login code: %w", err)
+		}
+		if attempt == nil {
+			return nil
+		}
+		return limiter.record(tx, attempt, ok)
+	})
+	if err != nil {
+		return err
+	}
+	return rejected
+}
+
+// RedeemLink spends the challenge a verified magic link names
+func (s *EmailLoginStore) RedeemLink(ctx context.Context, userID uuid.UUID, challengeID uuid.UUID) error {
+	result := s.db.WithContext(ctx).Model(&models.EmailLoginChallenge{}).
+		Where("id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", challengeID, userID, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to redeem login link: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrEmailLoginInvalid
+	}
+	return nil
+}
+
+// CleanupExpiredChallenges removes spent and expired challenges
+func (s *EmailLoginStore) CleanupExpiredChallenges(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Where("expires_at < ? OR used_at IS NOT NULL", time.Now()).
+		Delete(&models.EmailLoginChallenge{}).Error
+}
+
+// dummyCodeHash is compared against when there is no challenge, so a
+// rejection costs the same whether or not the address has an account
+func (s *EmailLoginStore) dummyCodeHash() []byte {
+	s.dummyOnce.Do(func() {
+		hash, err := HashPassword("000000", s.cfg)
+		if err == nil {
+			s.dummyHash = []byte(hash)
+		}
+	})
+	return s.dummyHash
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Policies for signing in with an unverified email
+const (
+	UnverifiedLoginAllow   = "allow"
+	UnverifiedLoginLimited = "limited"
+	UnverifiedLoginReject  = "reject"
+)
+
+// UnverifiedEmailScope is the scope first-party tokens of unverified users are
+// limited to under the "limited" policy
+const UnverifiedEmailScope = "users:read"
+
+var (
+	ErrEmailVerificationInvalid   = errors.New("email verification token is invalid or expired")
+	ErrEmailVerificationThrottled = errors.New("a verification email was sent recently")
+)
+
+// EmailVerificationStore handles email verification tokens
+type EmailVerificationStore struct {
+	db  *gorm.DB
+	cfg *config.EmailVerificationConfig
+}
+
+// NewEmailVerificationStore creates a new email verification store instance
+func NewEmailVerificationStore(db *gorm.DB, cfg *config.EmailVerificationConfig) *EmailVerificationStore {
+	return &EmailVerificationStore{db: db, cfg: cfg}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *EmailVerificationStore) WithTx(tx *gorm.DB) *EmailVerificationStore {
+	return &EmailVerificationStore{db: tx, cfg: s.cfg}
+}
+
+// CreateToken replaces the user's pending verification tokens with one for
+// the address and returns the plain token, which is only available now.
+// ErrEmailVerificationThrottled is returned while the last one is recent.
+func (s *EmailVerificationStore) CreateToken(ctx context.Context, userID uuid.UUID, email string) (string, error) {
+	plain, err := GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the user row so concurrent requests cannot both pass the check
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("user_id").First(&user, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var recent int64
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-emailResendInterval)).
+			Count(&recent).Error; err != nil {
+			return fmt.Errorf("failed to check verification tokens: %w", err)
+		}
+		if recent > 0 {
+			return ErrEmailVerificationThrottled
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete verification tokens: %w", err)
+		}
+
+		token := models.EmailVerificationToken{
+			UserID:    userID,
+			Email:     email,
+			TokenHash: HashSecret(plain),
+			ExpiresAt: time.Now().Add(s.cfg.TokenTTL),
+		}
+		if err := tx.Create(&token).Error; err != nil {
+			return fmt.Errorf("failed to store verification token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// VerifiedEmail is the outcome of redeeming a verification token
+type VerifiedEmail struct {
+	UserID uuid.UUID
+	Email  string
+	// PreviousEmail is set when the token confirmed a change of address
+	PreviousEmail string
+}
+
+// VerifyEmail spends a live verification token and marks the address it was
+// sent to as verified. A token for an address other than the user's current
+// one confirms a requested change, and the address is switched to it. That
+// fails with ErrEmailTaken if another account took the address meanwhile.
+func (s *EmailVerificationStore) VerifyEmail(ctx context.Context, plain string) (*VerifiedEmail, error) {
+	var verified *VerifiedEmail
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tokens []models.EmailVerificationToken
+		result := tx.Model(&tokens).Clauses(clause.Returning{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", HashSecret(plain), time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("failed to redeem verification token: %w", result.Error)
+		}
+		if len(tokens) == 0 {
+			return ErrEmailVerificationInvalid
+		}
+		token := tokens[0]
+
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "user_id = ?", token.UserID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrEmailVerificationInvalid
+			}
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+
+		verified = &VerifiedEmail{UserID: user.UserID, Email: token.Email}
+		if user.Email == token.Email {
+			if err := tx.Model(&user).Update("email_verified_at", gorm.Expr("COALESCE(email_verified_at, ?)", time.Now())).Error; err != nil {
+				return fmt.Errorf("failed to verify email: %w", err)
+			}
+			return nil
+		}
+
+		if err := NewProfileStore(tx).CheckEmailAvailable(ctx, user.UserID, token.Email); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"email":             token.Email,
+			"email_verified_at": time.Now(),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to change email: %w", err)
+		}
+		verified.PreviousEmail = user.Email
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// MarkVerified records that the user proved they receive mail at their
+// current address by other means, such as an emailed login code
+func (s *EmailVerificationStore) MarkVerified(ctx context.Context, userID uuid.UUID) error {
+	if err := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("user_id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	return nil
+}
+
+// CleanupExpiredTokens removes spent and expired verification tokens
+func (s *EmailVerificationStore) CleanupExpiredTokens(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Where("expires_at < ? OR used_at IS NOT NULL", time.Now()).
+		Delete(&models.EmailVerificationToken{}).Error
+}
+
+// UnverifiedLoginScope applies the unverified email policy to a sign in. It
+// returns the scope the tokens are limited to, empty for none, and whether the
+// user may sign in at all. Client tokens are limited with LimitScope.
+func UnverifiedLoginScope(cfg *config.Config, user *models.User) (string, bool) {
+	if !cfg.Features.EnableEmailVerification || user.EmailVerifiedAt != nil {
+		return "", true
+	}
+	switch cfg.Security.EmailVerification.UnverifiedLogin {
+	case UnverifiedLoginLimited:
+		return UnverifiedEmailScope, true
+	case UnverifiedLoginReject:
+		return "", false
+	default:
+		return "", true
+	}
+}
+
+// LimitScope returns the scopes of requested that are also in limit
+func LimitScope(requested string, limit string) string {
+	var scopes []string
+	for _, scope := range strings.Fields(requested) {
+		if HasScope(limit, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
@@ -8,14 +8,67 @@ import (
 	"github.com/HersheyPlus/go-auth/config"
 )
 
+// Token types carried in the token_type claim
+const (
+    TokenTypeAccess  = "access"
+    TokenTypeRefresh = "refresh"
+    TokenTypeMFAChallenge = "mfa_challenge" // proves the password step of a login awaiting a second factor
+    TokenTypeEmailLogin = "email_login" // magic link redeeming a passwordless email login
+)
+
+// Principal types carried in the principal_type claim. User tokens omit it.
+const (
+    PrincipalUser    = "user"
+    PrincipalService = "service"
+)
+
+// Validation errors, matchable with errors.Is
+var (
+    ErrTokenExpired     = errors.New("token has expired")
+    ErrTokenInvalid     = errors.New("token is invalid")
+    ErrInvalidIssuer    = errors.New("token has an invalid issuer")
+    ErrInvalidAudience  = errors.New("token has an invalid audience")
+    ErrInvalidTokenType = errors.New("token has an invalid type")
+)
+
 // Custom claims structure
 type Claims struct {
     UserID    string   `json:"user_id"`
     Username  string `json:"username"`
     TokenType string `json:"token_type"` // "access" or "refresh"
+    SessionID string `json:"sid,omitempty"`
+    ClientID  string `json:"client_id,omitempty"`
+    Scope     string `json:"scope,omitempty"`
+    Roles     []string `json:"roles,omitempty"`
+    Permissions []string `json:"permissions,omitempty"`
+    OrgID     string `json:"org_id,omitempty"`   // active organization, empty outside an org context
+    OrgRole   string `json:"org_role,omitempty"` // role in the active organization
+    PrincipalType string `json:"principal_type,omitempty"` // "service" for client credentials tokens
     jwt.RegisteredClaims
 }
 
+// Principal returns the kind of principal the token was issued to
+func (c *Claims) Principal() string {
+    if c.PrincipalType == PrincipalService {
+        return PrincipalService
+    }
+    return PrincipalUser
+}
+
+// TokenOptions describes who a token pair is issued to
+type TokenOptions struct {
+    UserID      string
+    Username    string
+    SessionID   string
+    ClientID    string   // OAuth client the tokens were issued to, empty for first-party logins
+    Scope       string   // space separated granted scopes
+    Roles       []string // role names, so services behind us can authorize offline
+    Permissions []string // effective permissions of the roles
+    OrgID       string   // active organization selected by switching org context
+    OrgRole     string
+    Service     bool     // issued to ClientID itself through the client credentials grant
+}
+
 type TokenDetails struct {
     AccessToken  string    `json:"access_token"`
     RefreshToken string    `json:"refresh_token"`
@@ -26,7 +79,7 @@ type TokenDetails struct {
 }
 
 // GenerateTokenPair generates both access and refresh tokens
-func GenerateTokenPair(userID string, username string, cfg *config.JWTConfig) (*TokenDetails, error) {
+func GenerateTokenPair(opts TokenOptions, cfg *config.JWTConfig) (*TokenDetails, error) {
     td := &TokenDetails{
         AccessUuid:  GenerateUUID(),
         RefreshUuid: GenerateUUID(),
@@ -34,14 +87,31 @@ func GenerateTokenPair(userID string, username string, cfg *config.JWTConfig) (*
         RtExpires:   time.Now().Add(cfg.RefreshTokenExpiry),
     }
 
+    accessRing, err := AccessKeyring()
+    if err != nil {
+        return nil, err
+    }
+    accessKey, err := accessRing.Active()
+    if err != nil {
+        return nil, err
+    }
+    refreshRing, err := RefreshKeyring()
+    if err != nil {
+        return nil, err
+    }
+    refreshKey, err := refreshRing.Active()
+    if err != nil {
+        return nil, err
+    }
+
     // Generate Access Token
     accessToken, err := generateToken(
-        userID,
-        username,
+        opts,
         td.AccessUuid,
-        "access",
+        TokenTypeAccess,
         td.AtExpires,
-        cfg.SecretKey,
+        accessKey,
+        cfg,
     )
     if err != nil {
         return nil, fmt.Errorf("failed to generate access token: %w", err)
@@ -50,12 +120,12 @@ func GenerateTokenPair(userID string, username string, cfg *config.JWTConfig) (*
 
     // Generate Refresh Token
     refreshToken, err := generateToken(
-        userID,
-        username,
+        opts,
         td.RefreshUuid,
-        "refresh",
+        TokenTypeRefresh,
         td.RtExpires,
-        cfg.RefreshKey,
+        refreshKey,
+        cfg,
     )
     if err != nil {
         return nil, fmt.Errorf("failed to generate refresh token: %w", err)
@@ -65,31 +135,83 @@ func GenerateTokenPair(userID string, username string, cfg *config.JWTConfig) (*
     return td, nil
 }
 
+// GenerateServiceToken generates an access token whose subject is the client
+// itself. No refresh token is issued, the client simply requests a new token.
+func GenerateServiceToken(clientID string, scope string, cfg *config.JWTConfig) (*TokenDetails, error) {
+    td := &TokenDetails{
+        AccessUuid: GenerateUUID(),
+        AtExpires:  time.Now().Add(cfg.AccessTokenExpiry),
+    }
+
+    accessRing, err := AccessKeyring()
+    if err != nil {
+        return nil, err
+    }
+    accessKey, err := accessRing.Active()
+    if err != nil {
+        return nil, err
+    }
+
+    accessToken, err := generateToken(
+        TokenOptions{ClientID: clientID, Scope: scope, Service: true},
+        td.AccessUuid,
+        TokenTypeAccess,
+        td.AtExpires,
+        accessKey,
+        cfg,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate access token: %w", err)
+    }
+    td.AccessToken = accessToken
+
+    return td, nil
+}
+
 // generateToken creates a new token depending on token type
 func generateToken(
-    userID string,
-    username string,
+    opts TokenOptions,
     uuid string,
     tokenType string,
     expiry time.Time,
-    secret string,
+    key *SigningKey,
+    cfg *config.JWTConfig,
 ) (string, error) {
+    subject := opts.UserID
+    principalType := ""
+    if opts.Service {
+        subject = opts.ClientID
+        principalType = PrincipalService
+    }
+
     claims := Claims{
-        UserID:    userID,
-        Username:  username,
+        UserID:    opts.UserID,
+        Username:  opts.Username,
         TokenType: tokenType,
+        SessionID: opts.SessionID,
+        ClientID:  opts.ClientID,
+        Scope:     opts.Scope,
+        Roles:     opts.Roles,
+        Permissions: opts.Permissions,
+        OrgID:     opts.OrgID,
+        OrgRole:   opts.OrgRole,
+        PrincipalType: principalType,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expiry),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
             NotBefore: jwt.NewNumericDate(time.Now()),
-            Subject:   userID,
+            Subject:   subject,
             ID:        uuid,
-            Issuer:    "your-app-name",
+            Issuer:    cfg.Issuer,
+            Audience:  jwt.ClaimStrings{cfg.Audience},
         },
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    signedToken, err := token.SignedString([]byte(secret))
+    token := jwt.NewWithClaims(key.Method, claims)
+    if key.ID != "" {
+        token.Header["kid"] = key.ID
+    }
+    signedToken, err := token.SignedString(key.PrivateKey)
     if err != nil {
         return "", fmt.Errorf("failed to sign token: %w", err)
     }
@@ -97,42 +219,154 @@ func generateToken(
     return signedToken, nil
 }
 
-// ValidateToken validates the token and returns the claims
-func ValidateToken(tokenString string, secret string) (*Claims, error) {
-    token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// GenerateMFAChallenge issues a short lived token for a user who passed the
+// password step. It is signed with the access key but its type keeps it from
+// being accepted as an access token.
+func GenerateMFAChallenge(userID string, ttl time.Duration, cfg *config.JWTConfig) (string, error) {
+    ring, err := AccessKeyring()
+    if err != nil {
+        return "", err
+    }
+    key, err := ring.Active()
+    if err != nil {
+        return "", err
+    }
+
+    return generateToken(
+        TokenOptions{UserID: userID},
+        GenerateUUID(),
+        TokenTypeMFAChallenge,
+        time.Now().Add(ttl),
+        key,
+        cfg,
+    )
+}
+
+// GenerateEmailLoginToken signs the magic link of a passwordless email login.
+// The token ID is the challenge it redeems, which keeps the link single-use.
+func GenerateEmailLoginToken(userID string, challengeID string, expiry time.Time, cfg *config.JWTConfig) (string, error) {
+    ring, err := AccessKeyring()
+    if err != nil {
+        return "", err
+    }
+    key, err := ring.Active()
+    if err != nil {
+        return "", err
+    }
+
+    return generateToken(TokenOptions{UserID: userID}, challengeID, TokenTypeEmailLogin, expiry, key, cfg)
+}
+
+// ValidateToken validates the token against the keyring, selecting the
+// verification key by the kid header. The issuer, audience and token type are
+// enforced and failures are reported with the typed errors above.
+func ValidateToken(tokenString string, keyring *Keyring, tokenType string, cfg *config.JWTConfig) (*Claims, error) {
+    keyFunc := func(token *jwt.Token) (interface{}, error) {
+        var key *SigningKey
+        if kid, ok := token.Header["kid"].(string); ok {
+            if key, ok = keyring.Lookup(kid); !ok {
+                return nil, fmt.Errorf("unknown key id: %s", kid)
+            }
+        } else {
+            // Tokens issued before key ids were introduced carry no kid
+            active, err := keyring.Active()
+            if err != nil {
+                return nil, err
+            }
+            key = active
+        }
+
+        if token.Method.Alg() != key.Method.Alg() {
             return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
         }
-        return []byte(secret), nil
-    })
+        return key.PublicKey, nil
+    }
+
+    options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+    if cfg.Issuer != "" {
+        options = append(options, jwt.WithIssuer(cfg.Issuer))
+    }
 
+    token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc, options...)
     if err != nil {
-        if errors.Is(err, jwt.ErrTokenExpired) {
-            return nil, fmt.Errorf("token has expired")
+        switch {
+        case errors.Is(err, jwt.ErrTokenExpired):
+            return nil, ErrTokenExpired
+        case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+            return nil, ErrInvalidIssuer
+        default:
+            return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
         }
-        return nil, fmt.Errorf("failed to parse token: %w", err)
     }
 
-    if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-        return claims, nil
+    claims, ok := token.Claims.(*Claims)
+    if !ok || !token.Valid {
+        return nil, ErrTokenInvalid
     }
 
-    return nil, fmt.Errorf("invalid token claims")
+    if !hasAcceptedAudience(claims.Audience, cfg) {
+        return nil, ErrInvalidAudience
+    }
+    if claims.TokenType != tokenType {
+        return nil, ErrInvalidTokenType
+    }
+
+    return claims, nil
 }
 
-// ExtractTokenMetadata extracts metadata from token
-func ExtractTokenMetadata(tokenString string, secret string) (*Claims, error) {
-    claims, err := ValidateToken(tokenString, secret)
+// ValidateAccessToken validates an access token against the access keyring
+func ValidateAccessToken(tokenString string, cfg *config.JWTConfig) (*Claims, error) {
+    keyring, err := AccessKeyring()
     if err != nil {
         return nil, err
     }
+    return ValidateToken(tokenString, keyring, TokenTypeAccess, cfg)
+}
 
-    // Validate token type
-    if claims.TokenType == "" {
-        return nil, fmt.Errorf("invalid token type")
+// ValidateRefreshToken validates a refresh token against the refresh keyring
+func ValidateRefreshToken(tokenString string, cfg *config.JWTConfig) (*Claims, error) {
+    keyring, err := RefreshKeyring()
+    if err != nil {
+        return nil, err
     }
+    return ValidateToken(tokenString, keyring, TokenTypeRefresh, cfg)
+}
 
-    return claims, nil
+// ValidateMFAChallenge validates an MFA challenge token against the access keyring
+func ValidateMFAChallenge(tokenString string, cfg *config.JWTConfig) (*Claims, error) {
+    keyring, err := AccessKeyring()
+    if err != nil {
+        return nil, err
+    }
+    return ValidateToken(tokenString, keyring, TokenTypeMFAChallenge, cfg)
+}
+
+// ValidateEmailLoginToken validates a magic link token against the access keyring
+func ValidateEmailLoginToken(tokenString string, cfg *config.JWTConfig) (*Claims, error) {
+    keyring, err := AccessKeyring()
+    if err != nil {
+        return nil, err
+    }
+    return ValidateToken(tokenString, keyring, TokenTypeEmailLogin, cfg)
+}
+
+// hasAcceptedAudience reports whether any token audience is accepted. The
+// audience tokens are issued for is always accepted.
+func hasAcceptedAudience(audience jwt.ClaimStrings, cfg *config.JWTConfig) bool {
+    if cfg.Audience == "" && len(cfg.AcceptedAudiences) == 0 {
+        return true
+    }
+    for _, aud := range audience {
+        if aud == cfg.Audience {
+            return true
+        }
+        for _, accepted := range cfg.AcceptedAudiences {
+            if aud == accepted {
+                return true
+            }
+        }
+    }
+    return false
 }
 
 // GenerateUUID generates a unique identifier for tokens
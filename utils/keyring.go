@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"gorm.io/gorm"
+)
+
+// Key purposes and statuses stored in the signing_keys table
+const (
+	KeyPurposeAccess  = "access"
+	KeyPurposeRefresh = "refresh"
+
+	KeyStatusActive = "active"
+	KeyStatusVerify = "verify"
+)
+
+var (
+	ErrKeyringNotLoaded  = errors.New("keyring is not loaded")
+	ErrNoActiveKey       = errors.New("keyring has no active signing key")
+	ErrSigningKeyUnknown = errors.New("signing key not found")
+)
+
+// Keyring holds one active signing key plus any number of verify-only keys
+type Keyring struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeyring builds a keyring from an active key and verify-only keys
+func NewKeyring(active *SigningKey, verify ...*SigningKey) *Keyring {
+	ring := &Keyring{active: active, keys: make(map[string]*SigningKey)}
+	if active != nil {
+		ring.keys[active.ID] = active
+	}
+	for _, key := range verify {
+		ring.keys[key.ID] = key
+	}
+	return ring
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() (*SigningKey, error) {
+	if k.active == nil {
+		return nil, ErrNoActiveKey
+	}
+	return k.active, nil
+}
+
+// Lookup returns the key with the given kid
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Keys returns every key in the ring ordered by kid
+func (k *Keyring) Keys() []*SigningKey {
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// instance
+var (
+	accessKeyring  atomic.Pointer[Keyring]
+	refreshKeyring atomic.Pointer[Keyring]
+)
+
+// AccessKeyring returns the loaded keyring for access tokens
+func AccessKeyring() (*Keyring, error) {
+	if ring := accessKeyring.Load(); ring != nil {
+		return ring, nil
+	}
+	return nil, ErrKeyringNotLoaded
+}
+
+// RefreshKeyring returns the loaded keyring for refresh tokens
+func RefreshKeyring() (*Keyring, error) {
+	if ring := refreshKeyring.Load(); ring != nil {
+		return ring, nil
+	}
+	return nil, ErrKeyringNotLoaded
+}
+
+// SetKeyrings replaces the loaded keyrings
+func SetKeyrings(access *Keyring, refresh *Keyring) {
+	accessKeyring.Store(access)
+	refreshKeyring.Store(refresh)
+}
+
+// LoadKeyrings seeds the keyring from the JWT config on first start and loads
+// the access and refresh keyrings from the database
+func LoadKeyrings(ctx context.Context, db *gorm.DB, cfg *config.JWTConfig) error {
+	store := NewKeyStore(db)
+	if err := store.Seed(ctx, cfg); err != nil {
+		return err
+	}
+	return store.Reload(ctx)
+}
+
+// WatchKeyrings reloads the keyrings at every interval so that keys promoted
+// by another instance or by the keys command are picked up without a restart
+func WatchKeyrings(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	store := NewKeyStore(db)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Reload(ctx); err != nil {
+				log.Printf("Failed to reload keyrings: %v", err)
+			}
+		}
+	}
+}
+
+// KeyStore manages the signing keys persisted in the database
+type KeyStore struct {
+	db *gorm.DB
+}
+
+// NewKeyStore creates a new key store instance
+func NewKeyStore(db *gorm.DB) *KeyStore {
+	return &KeyStore{db: db}
+}
+
+// Seed imports the keys from the JWT config as active keys when the keyring
+// for a purpose is still empty
+func (s *KeyStore) Seed(ctx context.Context, cfg *config.JWTConfig) error {
+	accessMaterial := []byte(cfg.SecretKey)
+	if cfg.Algorithm != AlgHS256 {
+		pemBytes, err := os.ReadFile(cfg.PrivateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read private key: %w", err)
+		}
+		accessMaterial = pemBytes
+	}
+
+	seeds := []struct {
+		purpose   string
+		algorithm string
+		kid       string
+		material  []byte
+	}{
+		{KeyPurposeAccess, cfg.Algorithm, cfg.KeyID, accessMaterial},
+		{KeyPurposeRefresh, AlgHS256, "refresh-default", []byte(cfg.RefreshKey)},
+	}
+
+	for _, seed := range seeds {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(&models.SigningKey{}).
+			Where("purpose = ?", seed.purpose).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count signing keys: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		key, err := NewSigningKey(seed.algorithm, seed.kid, seed.material)
+		if err != nil {
+			return fmt.Errorf("invalid %s signing key: %w", seed.purpose, err)
+		}
+		now := time.Now()
+		if err := s.db.WithContext(ctx).Create(&models.SigningKey{
+			KID:         key.ID,
+			Purpose:     seed.purpose,
+			Algorithm:   seed.algorithm,
+			KeyMaterial: string(seed.material),
+			Status:      KeyStatusActive,
+			ActivatedAt: &now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to seed %s signing key: %w", seed.purpose, err)
+		}
+		log.Printf("Seeded %s signing key %s from config", seed.purpose, key.ID)
+	}
+	return nil
+}
+
+// Reload reads both keyrings from the database and installs them
+func (s *KeyStore) Reload(ctx context.Context) error {
+	access, err := s.load(ctx, KeyPurposeAccess)
+	if err != nil {
+		return err
+	}
+	refresh, err := s.load(ctx, KeyPurposeRefresh)
+	if err != nil {
+		return err
+	}
+	SetKeyrings(access, refresh)
+	return nil
+}
+
+func (s *KeyStore) load(ctx context.Context, purpose string) (*Keyring, error) {
+	rows, err := s.List(ctx, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *SigningKey
+	var verify []*SigningKey
+	for _, row := range rows {
+		key, err := NewSigningKey(row.Algorithm, row.KID, []byte(row.KeyMaterial))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key %s: %w", row.KID, err)
+		}
+		if row.Status == KeyStatusActive {
+			active = key
+		} else {
+			verify = append(verify, key)
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("%s %w", purpose, ErrNoActiveKey)
+	}
+	return NewKeyring(active, verify...), nil
+}
+
+// List returns the stored keys for a purpose, or every key when purpose is empty
+func (s *KeyStore) List(ctx context.Context, purpose string) ([]models.SigningKey, error) {
+	query := s.db.WithContext(ctx).Order("purpose, created_at")
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	var rows []models.SigningKey
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	return rows, nil
+}
+
+// Generate creates a new verify-only key. Publishing it before promotion lets
+// verifiers pick it up from the JWKS before any token is signed with it.
+func (s *KeyStore) Generate(ctx context.Context, purpose string, algorithm string) (*models.SigningKey, error) {
+	if purpose != KeyPurposeAccess && purpose != KeyPurposeRefresh {
+		return nil, fmt.Errorf("unknown key purpose: %s", purpose)
+	}
+
+	material, err := generateKeyMaterial(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := ""
+	if algorithm == AlgHS256 {
+		suffix := make([]byte, 6)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, fmt.Errorf("failed to generate key id: %w", err)
+		}
+		kid = purpose + "-" + hex.EncodeToString(suffix)
+	}
+	key, err := NewSigningKey(algorithm, kid, material)
+	if err != nil {
+		return nil, err
+	}
+
+	row := &models.SigningKey{
+		KID:         key.ID,
+		Purpose:     purpose,
+		Algorithm:   algorithm,
+		KeyMaterial: string(material),
+		Status:      KeyStatusVerify,
+	}
+	if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+	return row, nil
+}
+
+// Promote makes the key the active signing key for its purpose. The previous
+// active key stays verify-only until every token it signed has expired.
+func (s *KeyStore) Promote(ctx context.Context, kid string, cfg *config.JWTConfig) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key models.SigningKey
+		if err := tx.First(&key, "kid = ?", kid).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrSigningKeyUnknown
+			}
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		if key.Status == KeyStatusActive {
+			return nil
+		}
+
+		lifetime := cfg.AccessTokenExpiry
+		if key.Purpose == KeyPurposeRefresh {
+			lifetime = cfg.RefreshTokenExpiry
+		}
+		now := time.Now()
+		retireAfter := now.Add(lifetime)
+
+		if err := tx.Model(&models.SigningKey{}).
+			Where("purpose = ? AND status = ?", key.Purpose, KeyStatusActive).
+			Updates(map[string]interface{}{
+				"status":       KeyStatusVerify,
+				"retire_after": retireAfter,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to demote active key: %w", err)
+		}
+
+		return tx.Model(&key).Updates(map[string]interface{}{
+			"status":       KeyStatusActive,
+			"activated_at": now,
+			"retire_after": nil,
+		}).Error
+	})
+}
+
+// Retire deletes the verify-only keys whose tokens have all expired and returns
+// their kids
+func (s *KeyStore) Retire(ctx context.Context) ([]string, error) {
+	var kids []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SigningKey{}).
+			Where("status = ? AND retire_after IS NOT NULL AND retire_after <= ?", KeyStatusVerify, time.Now()).
+			Pluck("kid", &kids).Error; err != nil {
+			return fmt.Errorf("failed to list retirable keys: %w", err)
+		}
+		if len(kids) == 0 {
+			return nil
+		}
+		return tx.Where("kid IN ?", kids).Delete(&models.SigningKey{}).Error
+	})
+	return kids, err
+}
+
+// generateKeyMaterial creates a new private key, PEM encoded, or an HMAC secret
+func generateKeyMaterial(algorithm string) ([]byte, error) {
+	var private interface{}
+	var err error
+
+	switch algorithm {
+	case AlgHS256:
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		return []byte(hex.EncodeToString(secret)), nil
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
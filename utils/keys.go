@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported access token signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a key used to sign and verify access tokens
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{} // []byte for HMAC, crypto.Signer otherwise
+	PublicKey  interface{} // []byte for HMAC, crypto.PublicKey otherwise
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewSigningKey builds a signing key for the algorithm. For HS256 the material
+// is the shared secret, every other algorithm expects a PEM encoded private key.
+func NewSigningKey(algorithm string, kid string, material []byte) (*SigningKey, error) {
+	if algorithm != AlgHS256 {
+		return ParseSigningKey(algorithm, kid, material)
+	}
+
+	if len(material) == 0 {
+		return nil, fmt.Errorf("secret is required for %s", AlgHS256)
+	}
+	if kid == "" {
+		kid = "default"
+	}
+	return &SigningKey{
+		ID:         kid,
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: material,
+		PublicKey:  material,
+	}, nil
+}
+
+// ParseSigningKey parses a PEM encoded private key for an asymmetric algorithm.
+// When kid is empty the RFC 7638 thumbprint of the public key is used.
+func ParseSigningKey(algorithm string, kid string, pemBytes []byte) (*SigningKey, error) {
+	key := &SigningKey{ID: kid}
+
+	switch algorithm {
+	case AlgRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.PrivateKey = private
+		key.PublicKey = &private.PublicKey
+	case AlgES256:
+		private, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EC private key: %w", err)
+		}
+		if private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s requires a P-256 key", AlgES256)
+		}
+		key.Method = jwt.SigningMethodES256
+		key.PrivateKey = private
+		key.PublicKey = &private.PublicKey
+	case AlgEdDSA:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Ed25519 private key: %w", err)
+		}
+		signer, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 key", AlgEdDSA)
+		}
+		key.Method = jwt.SigningMethodEdDSA
+		key.PrivateKey = signer
+		key.PublicKey = signer.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	if key.ID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+// IsSymmetric reports whether the key is a shared HMAC secret
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.PublicKey.([]byte)
+	return ok
+}
+
+// JWK returns the public part of the key in JWK format
+func (k *SigningKey) JWK() (JWK, error) {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+
+	switch public := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(public.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = encodeSegment(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(public)
+	default:
+		return JWK{}, fmt.Errorf("key %s has no publishable public key", k.ID)
+	}
+	return jwk, nil
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of the public key
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, err := k.JWK()
+	if err != nil {
+		return "", err
+	}
+
+	// Members must be in lexicographic order with no whitespace
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return encodeSegment(sum[:]), nil
+}
+
+// PublicJWKS returns the published verification keys: the active access key
+// and every verify-only key in the access keyring. Shared HMAC secrets are
+// never published, so the set is empty when access tokens use HS256.
+func PublicJWKS() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	ring, err := AccessKeyring()
+	if err != nil {
+		return set, err
+	}
+	for _, key := range ring.Keys() {
+		if key.IsSymmetric() {
+			continue
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			return set, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
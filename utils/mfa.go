@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMFANotEnrolled     = errors.New("no authenticator is enrolled")
+	ErrMFAAlreadyEnrolled = errors.New("an authenticator is already enrolled")
+	ErrMFAInvalidCode     = errors.New("invalid or already used authentication code")
+	ErrMFALocked          = errors.New("too many failed attempts, try again later")
+)
+
+// RecoveryCodeCount is the number of recovery codes in a set
+const RecoveryCodeCount = 10
+
+// MFAStore handles TOTP authenticator enrollment, recovery codes and second
+// factor verification
+type MFAStore struct {
+	db  *gorm.DB
+	cfg *config.SecurityConfig
+}
+
+// NewMFAStore creates a new MFA store instance
+func NewMFAStore(db *gorm.DB, cfg *config.SecurityConfig) *MFAStore {
+	return &MFAStore{db: db, cfg: cfg}
+}
+
+// BeginEnrollment generates a new secret for the user. It replaces any
+// unconfirmed enrollment but never a confirmed one.
+func (s *MFAStore) BeginEnrollment(ctx context.Context, userID uuid.UUID) (string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.TOTPAuthenticator
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existing, "user_id = ?", userID).Error
+		switch {
+		case err == nil && existing.ConfirmedAt != nil:
+			return ErrMFAAlreadyEnrolled
+		case err != nil && err != gorm.ErrRecordNotFound:
+			return fmt.Errorf("failed to load authenticator: %w", err)
+		}
+
+		authenticator := models.TOTPAuthenticator{UserID: userID, Secret: secret}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"secret", "last_used_step", "failed_attempts", "locked_until", "updated_at"}),
+		}).Create(&authenticator).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmEnrollment activates the pending enrollment once the user proves the
+// authenticator produces valid codes
+func (s *MFAStore) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) error {
+	var authenticator models.TOTPAuthenticator
+	if err := s.db.WithContext(ctx).First(&authenticator, "user_id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrMFANotEnrolled
+		}
+		return fmt.Errorf("failed to load authenticator: %w", err)
+	}
+	if authenticator.ConfirmedAt != nil {
+		return ErrMFAAlreadyEnrolled
+	}
+
+	step, ok := ValidateTOTP(authenticator.Secret, code, time.Now())
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.TOTPAuthenticator{}).
+		Where("user_id = ? AND confirmed_at IS NULL", userID).
+		Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+	if result.Error != nil {
+		return fmt.Errorf("failed to confirm authenticator: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFANotEnrolled
+	}
+	return nil
+}
+
+// Enabled reports whether the user has a confirmed authenticator
+func (s *MFAStore) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.TOTPAuthenticator{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check authenticator: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Verify checks a second factor answer, either a code from the confirmed
+// authenticator or an unused recovery code, and reports whether a recovery
+// code was spent. Each time step is accepted once, and repeated failures of
+// either kind lock verification for a while. Users without an authenticator,
+// who secure their account with passkeys, can still use their recovery codes.
+func (s *MFAStore) Verify(ctx context.Context, userID uuid.UUID, code string, recoveryCode string) (bool, error) {
+	// Failed attempts must be committed, so the rejection is returned after
+	// the transaction instead of rolling it back
+	var rejected error
+	var usedRecovery bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var authenticator models.TOTPAuthenticator
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+			First(&authenticator).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return fmt.Errorf("failed to load authenticator: %w", err)
+			}
+			if recoveryCode == "" {
+				return ErrMFANotEnrolled
+			}
+			err := s.verifyRecoveryCode(tx, userID, recoveryCode)
+			switch {
+			case errors.Is(err, ErrMFALocked), errors.Is(err, ErrMFAInvalidCode):
+				rejected = err
+				return nil
+			case err != nil:
+				return err
+			}
+			usedRecovery = true
+			return nil
+		}
+
+		now := time.Now()
+		if authenticator.LockedUntil != nil && now.Before(*authenticator.LockedUntil) {
+			rejected = ErrMFALocked
+			return nil
+		}
+
+		var ok bool
+		updates := map[string]interface{}{"failed_attempts": 0, "locked_until": nil}
+		if recoveryCode != "" {
+			used, err := s.useRecoveryCode(tx, userID, recoveryCode)
+			if err != nil {
+				return err
+			}
+			ok, usedRecovery = used, used
+		} else if step, valid := ValidateTOTP(authenticator.Secret, code, now); valid && step > authenticator.LastUsedStep {
+			ok = true
+			updates["last_used_step"] = step
+		}
+
+		if !ok {
+			updates = map[string]interface{}{"failed_attempts": authenticator.FailedAttempts + 1}
+			if authenticator.FailedAttempts+1 >= s.cfg.MFA.MaxFailedAttempts {
+				updates["failed_attempts"] = 0
+				updates["locked_until"] = now.Add(s.cfg.MFA.LockoutDuration)
+			}
+			rejected = ErrMFAInvalidCode
+		}
+		if err := tx.Model(&authenticator).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update authenticator: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return usedRecovery, rejected
+}
+
+// verifyRecoveryCode checks a recovery code of a user without an
+// authenticator. Failures are counted per user instead of on the
+// authenticator row.
+func (s *MFAStore) verifyRecoveryCode(tx *gorm.DB, userID uuid.UUID, code string) error {
+	var remaining int64
+	if err := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&remaining).Error; err != nil {
+		return fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	if remaining == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	limiter := attemptLimiter{kind: attemptRecoveryCode, maxAttempts: s.cfg.MFA.MaxFailedAttempts, lockout: s.cfg.MFA.LockoutDuration}
+	attempt, locked, err := limiter.lock(tx, userID)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return ErrMFALocked
+	}
+
+	used, err := s.useRecoveryCode(tx, userID, code)
+	if err != nil {
+		return err
+	}
+	if err := limiter.record(tx, attempt, used); err != nil {
+		return err
+	}
+	if !used {
+		return ErrMFAInvalidCode
+	}
+	return nil
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes with a new set and
+// returns the plain codes, which are only available now
+func (s *MFAStore) GenerateRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := HashPassword(normalizeRecoveryCode(code), s.cfg)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to store recovery codes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func (s *MFAStore) RemainingRecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// useRecoveryCode marks the matching unused code as used
+func (s *MFAStore) useRecoveryCode(tx *gorm.DB, userID uuid.UUID, code string) (bool, error) {
+	var candidates []models.RecoveryCode
+	if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Find(&candidates).Error; err != nil {
+		return false, fmt.Errorf("failed to load recovery codes: %w", err)
+	}
+
+	code = normalizeRecoveryCode(code)
+	for _, candidate := range candidates {
+		if ComparePasswords(candidate.CodeHash, code) != nil {
+			continue
+		}
+		if err := tx.Model(&candidate).Update("used_at", time.Now()).Error; err != nil {
+			return false, fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// generateRecoveryCode returns a code such as "k3q9x-mz2rt"
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Disable removes the user's authenticator. The recovery codes go with it,
+// unless the user keeps passkeys they still stand in for.
+func (s *MFAStore) Disable(ctx context.Context, userID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userID).Delete(&models.TOTPAuthenticator{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to disable authenticator: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFANotEnrolled
+		}
+
+		var passkeys int64
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&passkeys).Error; err != nil {
+			return fmt.Errorf("failed to count passkeys: %w", err)
+		}
+		if passkeys > 0 {
+			return nil
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
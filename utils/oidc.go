@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect scopes (OIDC Core section 5.4)
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+)
+
+// UserClaims are the standard claims released for the granted scopes
+// (OIDC Core section 5.1). The subject is carried separately.
+type UserClaims struct {
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	PhoneNumber       string `json:"phone_number,omitempty"`
+}
+
+// UserInfo is the userinfo endpoint response (OIDC Core section 5.3.2)
+type UserInfo struct {
+	Subject string `json:"sub"`
+	UserClaims
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	UserClaims
+	Nonce     string           `json:"nonce,omitempty"`
+	AuthTime  *jwt.NumericDate `json:"auth_time,omitempty"`
+	SessionID string           `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenOptions describes the authentication an ID token is issued for
+type IDTokenOptions struct {
+	ClientID  string
+	Scope     string
+	Nonce     string
+	SessionID string
+	AuthTime  time.Time
+}
+
+// HasScope reports whether the space separated scope contains the given scope
+func HasScope(scope string, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUserClaims builds the claims of the user released for the scope
+func NewUserClaims(user *models.User, scope string) UserClaims {
+	var claims UserClaims
+
+	if HasScope(scope, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+		if user.FirstName != nil {
+			claims.GivenName = *user.FirstName
+		}
+		if user.LastName != nil {
+			claims.FamilyName = *user.LastName
+		}
+		claims.Name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+	if HasScope(scope, ScopeEmail) {
+		claims.Email = user.Email
+	}
+	if HasScope(scope, ScopePhone) {
+		claims.PhoneNumber = user.Phone
+	}
+	return claims
+}
+
+// GenerateIDToken signs an ID token for the client with the active access key,
+// so it can be verified against the published JWKS
+func GenerateIDToken(user *models.User, opts IDTokenOptions, jwtCfg *config.JWTConfig, ttl time.Duration) (string, error) {
+	ring, err := AccessKeyring()
+	if err != nil {
+		return "", err
+	}
+	key, err := ring.Active()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := IDTokenClaims{
+		UserClaims: NewUserClaims(user, opts.Scope),
+		Nonce:      opts.Nonce,
+		SessionID:  opts.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtCfg.Issuer,
+			Subject:   user.UserID.String(),
+			Audience:  jwt.ClaimStrings{opts.ClientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if !opts.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(opts.AuthTime)
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign id token: %w", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles a user can hold inside an organization
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var (
+	ErrMembershipNotFound   = errors.New("user is not a member of the organization")
+	ErrLastOwner            = errors.New("the last owner cannot leave the organization")
+	ErrInvalidOrgRole       = errors.New("invalid organization role")
+	ErrInvitationInvalid    = errors.New("invitation is invalid or expired")
+	ErrInvitationNotFound   = errors.New("invitation not found")
+	ErrInvitationWrongEmail = errors.New("invitation was sent to a different email address")
+	ErrAlreadyMember        = errors.New("user is already a member of the organization")
+)
+
+// OrganizationMembership is an organization seen from one of its members
+type OrganizationMembership struct {
+	models.Organization
+	Role     string
+	JoinedAt time.Time
+}
+
+// OrganizationMember is a member seen from the organization
+type OrganizationMember struct {
+	UserID   uuid.UUID
+	Username string
+	Email    string
+	Role     string
+	JoinedAt time.Time
+}
+
+// IsOrgRole reports whether the role is a valid organization role
+func IsOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// OrganizationStore handles organizations, memberships and invitations
+type OrganizationStore struct {
+	db *gorm.DB
+}
+
+// NewOrganizationStore creates a new organization store instance
+func NewOrganizationStore(db *gorm.DB) *OrganizationStore {
+	return &OrganizationStore{db: db}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *OrganizationStore) WithTx(tx *gorm.DB) *OrganizationStore {
+	return &OrganizationStore{db: tx}
+}
+
+// CreateOrganization creates an organization owned by the user
+func (s *OrganizationStore) CreateOrganization(ctx context.Context, name string, ownerID uuid.UUID) (*models.Organization, error) {
+	org := &models.Organization{Name: name}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+		owner := models.Membership{OrganizationID: org.ID, UserID: ownerID, Role: OrgRoleOwner}
+		if err := tx.Create(&owner).Error; err != nil {
+			return fmt.Errorf("failed to add organization owner: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// ListUserOrganizations returns the organizations the user belongs to
+func (s *OrganizationStore) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]OrganizationMembership, error) {
+	var orgs []OrganizationMembership
+	if err := s.db.WithContext(ctx).Model(&models.Organization{}).
+		Select("organizations.*, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN memberships ON memberships.organization_id = organizations.id").
+		Where("memberships.user_id = ?", userID).
+		Order("organizations.name").
+		Scan(&orgs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// GetMembership returns the user's membership in the organization
+func (s *OrganizationStore) GetMembership(ctx context.Context, orgID uuid.UUID, userID uuid.UUID) (*models.Membership, error) {
+	var membership models.Membership
+	if err := s.db.WithContext(ctx).
+		Where("organization_id = ? AND user_id = ?", orgID, userID).
+		First(&membership).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrMembershipNotFound
+		}
+		return nil, fmt.Errorf("failed to load membership: %w", err)
+	}
+	return &membership, nil
+}
+
+// ListMembers returns the members of the organization
+func (s *OrganizationStore) ListMembers(ctx context.Context, orgID uuid.UUID) ([]OrganizationMember, error) {
+	var members []OrganizationMember
+	if err := s.db.WithContext(ctx).Table("memberships").
+		Select("memberships.user_id, users.username, users.email, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN users ON users.user_id = memberships.user_id AND users.deleted_at IS NULL").
+		Where("memberships.organization_id = ?", orgID).
+		Order("memberships.created_at").
+		Scan(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return members, nil
+}
+
+// RemoveMember removes a user from the organization. The last owner cannot be
+// removed so that the organization is never left without one.
+func (s *OrganizationStore) RemoveMember(ctx context.Context, orgID uuid.UUID, userID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serialize removals in the organization, so that owners removing each
+		// other at the same time cannot both see another owner left
+		var org models.Organization
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&org, "id = ?", orgID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrMembershipNotFound
+			}
+			return fmt.Errorf("failed to lock organization: %w", err)
+		}
+
+		var membership models.Membership
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("organization_id = ? AND user_id = ?", orgID, userID).
+			First(&membership).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrMembershipNotFound
+			}
+			return fmt.Errorf("failed to load membership: %w", err)
+		}
+
+		if membership.Role == OrgRoleOwner {
+			var owners int64
+			if err := tx.Model(&models.Membership{}).
+				Where("organization_id = ? AND role = ?", orgID, OrgRoleOwner).
+				Count(&owners).Error; err != nil {
+				return fmt.Errorf("failed to count owners: %w", err)
+			}
+			if owners <= 1 {
+				return ErrLastOwner
+			}
+		}
+
+		return tx.Where("organization_id = ? AND user_id = ?", orgID, userID).
+			Delete(&models.Membership{}).Error
+	})
+}
+
+// CreateInvitation invites the email address to the organization and returns
+// the invitation with its plain acceptance token
+func (s *OrganizationStore) CreateInvitation(ctx context.Context, orgID uuid.UUID, email string, role string, invitedBy uuid.UUID, ttl time.Duration) (*models.Invitation, string, error) {
+	if !IsOrgRole(role) {
+		return nil, "", ErrInvalidOrgRole
+	}
+
+	token, err := GenerateSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invitation := &models.Invitation{
+		OrganizationID: orgID,
+		Email:          strings.ToLower(email),
+		Role:           role,
+		TokenHash:      HashSecret(token),
+		InvitedBy:      invitedBy,
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+	if err := s.db.WithContext(ctx).Create(invitation).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return invitation, token, nil
+}
+
+// ListInvitations returns the pending invitations of the organization
+func (s *OrganizationStore) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	if err := s.db.WithContext(ctx).
+		Where("organization_id = ? AND accepted_at IS NULL AND expires_at > ?", orgID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation deletes a pending invitation
+func (s *OrganizationStore) RevokeInvitation(ctx context.Context, orgID uuid.UUID, invitationID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("id = ? AND organization_id = ? AND accepted_at IS NULL", invitationID, orgID).
+		Delete(&models.Invitation{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation adds the user to the organization of the invitation. The
+// invitation must have been sent to the user's email address.
+func (s *OrganizationStore) AcceptInvitation(ctx context.Context, token string, user *models.User) (*models.Membership, error) {
+	var membership *models.Membership
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invitation models.Invitation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", HashSecret(token), time.Now()).
+			First(&invitation).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvitationInvalid
+			}
+			return fmt.Errorf("failed to load invitation: %w", err)
+		}
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			return ErrInvitationWrongEmail
+		}
+
+		// Serialize with an owner deleting their account, who may only leave
+		// an organization without an owner if nobody else is in it
+		var org models.Organization
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&org, "id = ?", invitation.OrganizationID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvitationInvalid
+			}
+			return fmt.Errorf("failed to lock organization: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&models.Membership{}).
+			Where("organization_id = ? AND user_id = ?", invitation.OrganizationID, user.UserID).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check membership: %w", err)
+		}
+		if count > 0 {
+			return ErrAlreadyMember
+		}
+
+		membership = &models.Membership{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         user.UserID,
+			Role:           invitation.Role,
+		}
+		if err := tx.Create(membership).Error; err != nil {
+			return fmt.Errorf("failed to create membership: %w", err)
+		}
+		return tx.Model(&invitation).Update("accepted_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return membership, nil
+}
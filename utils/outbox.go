@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox topics
+const (
+	OutboxTopicEmail = "email"
+	OutboxTopicEvent = "event"
+)
+
+// User events delivered to the webhook
+const (
+	EventUserRegistered  = "user.registered"
+	EventEmailVerified   = "user.email_verified"
+	EventEmailChanged    = "user.email_changed"
+	EventPasswordChanged = "user.password_changed"
+	EventProfileUpdated  = "user.profile_updated"
+	EventAccountDeleted  = "user.deleted"
+	EventAccountRestored = "user.restored"
+	EventAccountPurged   = "user.purged"
+)
+
+var (
+	ErrOutboxMessageNotFound = errors.New("outbox message not found")
+	ErrOutboxMessageRedacted = errors.New("outbox message was redacted and cannot be delivered again")
+)
+
+// outboxPurgeInterval is how often the dispatcher purges expired dead letters
+const outboxPurgeInterval = time.Hour
+
+// redactedEmailFields are what a dead-lettered email keeps of its payload,
+// enough to tell who missed which message without the links and codes it
+// carried
+var redactedEmailFields = []string{"to", "subject", "template"}
+
+// OutboxEvent is the payload of an event entry
+type OutboxEvent struct {
+	Type       string      `json:"type"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Data       interface{} `json:"data,omitempty"`
+	OccurredAt time.Time   `json:"occurred_at"`
+}
+
+// OutboxHandler delivers an entry of its topic. Entries are delivered at least
+// once, so handlers may see one again and can use its ID to tell.
+type OutboxHandler func(ctx context.Context, msg *models.OutboxMessage) error
+
+// OutboxStore writes and settles outbox entries
+type OutboxStore struct {
+	db *gorm.DB
+}
+
+// NewOutboxStore creates a new outbox store instance
+func NewOutboxStore(db *gorm.DB) *OutboxStore {
+	return &OutboxStore{db: db}
+}
+
+// WithTx returns a store that writes entries in the given transaction, so
+// they are only delivered if it commits
+func (s *OutboxStore) WithTx(tx *gorm.DB) *OutboxStore {
+	return &OutboxStore{db: tx}
+}
+
+// Enqueue stores the payload, encoded as JSON, for delivery on the topic
+func (s *OutboxStore) Enqueue(ctx context.Context, topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox payload: %w", err)
+	}
+	msg := models.OutboxMessage{
+		Topic:         topic,
+		Payload:       string(data),
+		NextAttemptAt: time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Create(&msg).Error; err != nil {
+		return fmt.Errorf("failed to store outbox message: %w", err)
+	}
+	return nil
+}
+
+// EnqueueEvent stores a user event for the webhook
+func (s *OutboxStore) EnqueueEvent(ctx context.Context, eventType string, userID uuid.UUID, data interface{}) error {
+	return s.Enqueue(ctx, OutboxTopicEvent, OutboxEvent{
+		Type:       eventType,
+		UserID:     userID,
+		Data:       data,
+		OccurredAt: time.Now(),
+	})
+}
+
+// ListDead returns the dead-lettered entries, oldest first
+func (s *OutboxStore) ListDead(ctx context.Context) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	if err := s.db.WithContext(ctx).Where("dead_at IS NOT NULL").Order("created_at").Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to list dead outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+// Retry queues a dead-lettered entry for delivery again with fresh attempts.
+// Redacted emails cannot be retried, the user asks for a new one instead.
+func (s *OutboxStore) Retry(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Model(&models.OutboxMessage{}).
+		Where("id = ? AND dead_at IS NOT NULL AND redacted = ?", id, false).
+		Updates(map[string]interface{}{"dead_at": nil, "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil {
+		return fmt.Errorf("failed to retry outbox message: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.OutboxMessage{}).
+		Where("id = ? AND dead_at IS NOT NULL", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to retry outbox message: %w", err)
+	}
+	if count > 0 {
+		return ErrOutboxMessageRedacted
+	}
+	return ErrOutboxMessageNotFound
+}
+
+// PurgeDead deletes the entries dead-lettered before the cutoff
+func (s *OutboxStore) PurgeDead(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("dead_at < ?", before).Delete(&models.OutboxMessage{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge dead outbox messages: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// claim picks due entries and hides them from other dispatchers for the
+// lease, after which entries that were never settled become due again
+func (s *OutboxStore) claim(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("dead_at IS NULL AND next_attempt_at <= ?", time.Now()).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&messages).Error; err != nil {
+			return fmt.Errorf("failed to claim outbox messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(messages))
+		for i := range messages {
+			ids[i] = messages[i].ID
+		}
+		if err := tx.Model(&models.OutboxMessage{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(lease)).Error; err != nil {
+			return fmt.Errorf("failed to lease outbox messages: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// OutboxDispatcher delivers outbox entries to the handlers of their topics,
+// retrying failures with exponential backoff until they are dead-lettered
+type OutboxDispatcher struct {
+	store    *OutboxStore
+	cfg      *config.OutboxConfig
+	logger   *log.Logger
+	handlers map[string]OutboxHandler
+}
+
+// NewOutboxDispatcher creates a dispatcher without handlers
+func NewOutboxDispatcher(db *gorm.DB, cfg *config.OutboxConfig) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		store:    NewOutboxStore(db),
+		cfg:      cfg,
+		logger:   log.New(log.Writer(), "Outbox: ", log.LstdFlags),
+		handlers: make(map[string]OutboxHandler),
+	}
+}
+
+// Handle registers the handler for a topic. Entries of topics without a
+// handler fail and are eventually dead-lettered.
+func (d *OutboxDispatcher) Handle(topic string, handler OutboxHandler) {
+	d.handlers[topic] = handler
+}
+
+// Run delivers due entries at every poll interval and purges expired dead
+// letters until ctx is done
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(outboxPurgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		case <-purgeTicker.C:
+			purged, err := d.store.PurgeDead(ctx, time.Now().Add(-d.cfg.DeadRetention))
+			if err != nil {
+				d.logger.Printf("Failed to purge dead messages: %v", err)
+			}
+			if purged > 0 {
+				d.logger.Printf("Purged %d dead messages", purged)
+			}
+		}
+	}
+}
+
+// dispatch delivers batches until no due entries are left
+func (d *OutboxDispatcher) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := d.store.claim(ctx, d.cfg.BatchSize, d.cfg.LeaseTimeout)
+		if err != nil {
+			d.logger.Printf("Failed to claim messages: %v", err)
+			return
+		}
+		for i := range messages {
+			d.deliver(ctx, &messages[i])
+		}
+		if len(messages) < d.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// deliver hands the entry to its handler and settles it. Delivered entries
+// are deleted, failed ones are scheduled again or dead-lettered.
+func (d *OutboxDispatcher) deliver(ctx context.Context, msg *models.OutboxMessage) {
+	err := fmt.Errorf("no handler for topic %q", msg.Topic)
+	if handler, ok := d.handlers[msg.Topic]; ok {
+		err = handler(ctx, msg)
+	}
+
+	db := d.store.db.WithContext(ctx)
+	if err == nil {
+		if err := db.Delete(&models.OutboxMessage{}, "id = ?", msg.ID).Error; err != nil {
+			d.logger.Printf("Failed to delete delivered message %s: %v", msg.ID, err)
+		}
+		return
+	}
+
+	attempts := msg.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts, "last_error": err.Error()}
+	if attempts >= d.cfg.MaxAttempts {
+		updates["dead_at"] = time.Now()
+		if msg.Topic == OutboxTopicEmail {
+			updates["payload"] = redactEmail(msg.Payload)
+			updates["redacted"] = true
+		}
+		d.logger.Printf("Dead-lettered %s message %s after %d attempts: %v", msg.Topic, msg.ID, attempts, err)
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(d.backoff(attempts))
+		d.logger.Printf("Failed to deliver %s message %s (attempt %d): %v", msg.Topic, msg.ID, attempts, err)
+	}
+	if err := db.Model(&models.OutboxMessage{}).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		d.logger.Printf("Failed to record delivery failure of message %s: %v", msg.ID, err)
+	}
+}
+
+// backoff doubles the initial delay for every failed attempt, up to the max
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// redactEmail strips a dead-lettered email payload down to the
+// redactedEmailFields. Undecodable payloads are dropped entirely.
+func redactEmail(payload string) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "{}"
+	}
+	redacted := make(map[string]json.RawMessage, len(redactedEmailFields))
+	for _, name := range redactedEmailFields {
+		if value, ok := fields[name]; ok {
+			redacted[name] = value
+		}
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
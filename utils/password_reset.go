@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPasswordResetInvalid   = errors.New("password reset token is invalid or expired")
+	ErrPasswordResetThrottled = errors.New("a password reset was sent recently")
+)
+
+// PasswordResetStore handles password reset tokens
+type PasswordResetStore struct {
+	db  *gorm.DB
+	cfg *config.PasswordResetConfig
+}
+
+// NewPasswordResetStore creates a new password reset store instance
+func NewPasswordResetStore(db *gorm.DB, cfg *config.PasswordResetConfig) *PasswordResetStore {
+	return &PasswordResetStore{db: db, cfg: cfg}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *PasswordResetStore) WithTx(tx *gorm.DB) *PasswordResetStore {
+	return &PasswordResetStore{db: tx, cfg: s.cfg}
+}
+
+// CreateToken replaces the user's pending reset tokens with a new one and
+// returns the plain token, which is only available now.
+// ErrPasswordResetThrottled is returned while the last one is recent.
+func (s *PasswordResetStore) CreateToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	plain, err := GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the user row so concurrent requests cannot both pass the check
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("user_id").First(&user, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var recent int64
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-emailResendInterval)).
+			Count(&recent).Error; err != nil {
+			return fmt.Errorf("failed to check reset tokens: %w", err)
+		}
+		if recent > 0 {
+			return ErrPasswordResetThrottled
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete reset tokens: %w", err)
+		}
+
+		token := models.PasswordResetToken{
+			UserID:    userID,
+			TokenHash: HashSecret(plain),
+			ExpiresAt: time.Now().Add(s.cfg.TokenTTL),
+		}
+		if err := tx.Create(&token).Error; err != nil {
+			return fmt.Errorf("failed to store reset token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// RedeemToken spends a live reset token and returns the user it was issued to
+func (s *PasswordResetStore) RedeemToken(ctx context.Context, plain string) (uuid.UUID, error) {
+	var tokens []models.PasswordResetToken
+	result := s.db.WithContext(ctx).Model(&tokens).Clauses(clause.Returning{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", HashSecret(plain), time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return uuid.Nil, fmt.Errorf("failed to redeem reset token: %w", result.Error)
+	}
+	if len(tokens) == 0 {
+		return uuid.Nil, ErrPasswordResetInvalid
+	}
+	return tokens[0].UserID, nil
+}
+
+// CleanupExpiredTokens removes spent and expired reset tokens
+func (s *PasswordResetStore) CleanupExpiredTokens(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Where("expires_at < ? OR used_at IS NOT NULL", time.Now()).
+		Delete(&models.PasswordResetToken{}).Error
+}
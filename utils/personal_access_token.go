@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so that they can be
+// told apart from JWTs and picked up by secret scanners
+const PersonalAccessTokenPrefix = "goauth_pat_"
+
+// lastUsedResolution limits how often last_used_at is written for a token
+const lastUsedResolution = time.Minute
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrPersonalAccessTokenInvalid  = errors.New("personal access token is invalid or expired")
+)
+
+// PersonalAccessTokenStore handles personal access token operations
+type PersonalAccessTokenStore struct {
+	db *gorm.DB
+}
+
+// NewPersonalAccessTokenStore creates a new personal access token store instance
+func NewPersonalAccessTokenStore(db *gorm.DB) *PersonalAccessTokenStore {
+	return &PersonalAccessTokenStore{db: db}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *PersonalAccessTokenStore) WithTx(tx *gorm.DB) *PersonalAccessTokenStore {
+	return &PersonalAccessTokenStore{db: tx}
+}
+
+// IsPersonalAccessToken reports whether the bearer token is a personal access token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// CreateToken stores a new token and returns it with the plain token, which
+// is only available at creation time
+func (s *PersonalAccessTokenStore) CreateToken(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*models.PersonalAccessToken, string, error) {
+	secret, err := GenerateSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := PersonalAccessTokenPrefix + secret
+
+	token := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashSecret(plain),
+		TokenHint: plain[len(plain)-4:],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.WithContext(ctx).Create(token).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create personal access token: %w", err)
+	}
+	return token, plain, nil
+}
+
+// ListTokens returns the tokens of a user, newest first
+func (s *PersonalAccessTokenStore) ListTokens(ctx context.Context, userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to list personal access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes a token of the user
+func (s *PersonalAccessTokenStore) RevokeToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", tokenID, userID).
+		Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke personal access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+// RevokeAllTokens deletes every token of the user and returns how many there were
+func (s *PersonalAccessTokenStore) RevokeAllTokens(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke personal access tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Authenticate looks up an unexpired token and records its use
+func (s *PersonalAccessTokenStore) Authenticate(ctx context.Context, plain string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := s.db.WithContext(ctx).
+		Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", HashSecret(plain), time.Now()).
+		First(&token).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPersonalAccessTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to load personal access token: %w", err)
+	}
+
+	// Avoid a write on every request from busy scripts
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedResolution {
+		if err := s.db.WithContext(ctx).Model(&token).Update("last_used_at", now).Error; err != nil {
+			return nil, fmt.Errorf("failed to record personal access token use: %w", err)
+		}
+		token.LastUsedAt = &now
+	}
+	return &token, nil
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already in use")
+)
+
+// ProfileStore checks profile changes against other users
+type ProfileStore struct {
+	db *gorm.DB
+}
+
+// NewProfileStore creates a new profile store instance
+func NewProfileStore(db *gorm.DB) *ProfileStore {
+	return &ProfileStore{db: db}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *ProfileStore) WithTx(tx *gorm.DB) *ProfileStore {
+	return &ProfileStore{db: tx}
+}
+
+// ClaimUsername fails with ErrUsernameTaken when another user has the
+// username in any case. The name stays locked until the transaction ends, so
+// the caller must set it in the same transaction.
+func (s *ProfileStore) ClaimUsername(ctx context.Context, userID uuid.UUID, username string) error {
+	name := strings.ToLower(username)
+	db := s.db.WithContext(ctx)
+	if err := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "username:"+name).Error; err != nil {
+		return fmt.Errorf("failed to lock username: %w", err)
+	}
+
+	var count int64
+	// Accounts awaiting deletion keep their name, they may be restored
+	if err := db.Unscoped().Model(&models.User{}).
+		Where("LOWER(username) = ? AND user_id <> ?", name, userID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+	if count > 0 {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+// CheckEmailAvailable fails with ErrEmailTaken when another user has the address
+func (s *ProfileStore) CheckEmailAvailable(ctx context.Context, userID uuid.UUID, email string) error {
+	var count int64
+	if err := s.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("email = ? AND user_id <> ?", strings.ToLower(email), userID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
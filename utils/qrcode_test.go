@@ -0,0 +1,533 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+// The symbols are read back by a decoder written from ISO/IEC 18004 with the
+// tables of the specification rather than the encoder's derivations, so that
+// a mistake in the encoder is not mirrored here.
+
+// Codewords per version in total and for data at level M
+var (
+	qrTestTotalCodewords = [41]int{0,
+		26, 44, 70, 100, 134, 172, 196, 242, 292, 346, 404, 466, 532, 581, 655, 733, 815, 901, 991, 1085,
+		1156, 1258, 1364, 1474, 1588, 1706, 1828, 1921, 2051, 2185, 2323, 2465, 2611, 2761, 2876, 3034, 3196, 3362, 3532, 3706}
+	qrTestDataCodewordsM = [41]int{0,
+		16, 28, 44, 64, 86, 108, 124, 154, 182, 216, 254, 290, 334, 365, 415, 453, 507, 563, 627, 669,
+		714, 782, 860, 914, 1000, 1062, 1128, 1193, 1267, 1373, 1455, 1541, 1631, 1725, 1812, 1914, 1992, 2102, 2216, 2334}
+	qrTestBlocksM = [41]int{0,
+		1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16,
+		17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+	// Modules left over after the last codeword
+	qrTestRemainderBits = [41]int{0,
+		0, 7, 7, 7, 7, 7, 0, 0, 0, 0, 0, 0, 0, 3, 3, 3, 3, 3, 3, 3,
+		4, 4, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 0, 0, 0, 0, 0, 0}
+)
+
+// Alignment pattern centre coordinates per version
+var qrTestAlignmentCentres = [41][]int{nil, nil,
+	{6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}, {6, 30, 54}, {6, 32, 58}, {6, 34, 62},
+	{6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74}, {6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+	{6, 28, 50, 72, 94}, {6, 26, 50, 74, 98}, {6, 30, 54, 78, 102}, {6, 28, 54, 80, 106}, {6, 32, 58, 84, 110}, {6, 30, 58, 86, 114}, {6, 34, 62, 90, 118},
+	{6, 26, 50, 74, 98, 122}, {6, 30, 54, 78, 102, 126}, {6, 26, 52, 78, 104, 130}, {6, 30, 56, 82, 108, 134}, {6, 34, 60, 86, 112, 138}, {6, 30, 58, 86, 114, 142}, {6, 34, 62, 90, 118, 146},
+	{6, 30, 54, 78, 102, 126, 150}, {6, 24, 50, 76, 102, 128, 154}, {6, 28, 54, 80, 106, 132, 158}, {6, 32, 58, 84, 110, 136, 162}, {6, 26, 54, 82, 110, 138, 166}, {6, 30, 58, 86, 114, 142, 170},
+}
+
+// qrTestFormatM lists the masked format information of level M, indexed by mask
+var qrTestFormatM = [8]int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+
+type qrTestSymbol struct {
+	text    string
+	version int
+	mask    int
+}
+
+// qrTestCapacity is the number of bytes a version holds at level M
+func qrTestCapacity(version int) int {
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	return (qrTestDataCodewordsM[version]*8 - 4 - countBits) / 8
+}
+
+// qrTestData returns length bytes. Lengths an enrollment URI fits in get one,
+// padded with its account name, shorter ones arbitrary bytes.
+func qrTestData(length int, secret string) string {
+	base := TOTPURI("Go Auth", "", secret)
+	if length >= len(base) {
+		return TOTPURI("Go Auth", strings.Repeat("a", length-len(base)), secret)
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(i * 37)
+	}
+	return string(data)
+}
+
+func TestQRCodePNGRoundTrip(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := TOTPURI("Go Auth", "alice@example.com", secret)
+	png, err := QRCodePNG(uri, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeTestQRCodePNG(t, png); got.text != uri {
+		t.Fatalf("decoded %q, want %q", got.text, uri)
+	}
+
+	// The largest payload of each version, and one byte more for the next
+	for version := 1; version <= 40; version++ {
+		lengths := []int{qrTestCapacity(version)}
+		if version > 1 {
+			lengths = append(lengths, qrTestCapacity(version-1)+1)
+		}
+		for _, length := range lengths {
+			t.Run(fmt.Sprintf("version %d length %d", version, length), func(t *testing.T) {
+				text := qrTestData(length, secret)
+				png, err := QRCodePNG(text, 1+version%3)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := decodeTestQRCodePNG(t, png)
+				if got.version != version {
+					t.Errorf("encoded as version %d, want %d", got.version, version)
+				}
+				if got.text != text {
+					t.Errorf("decoded %q, want %q", got.text, text)
+				}
+			})
+		}
+	}
+}
+
+// Some masks are rarely the best, the inputs run until each has been chosen
+func TestQRCodeUsesEveryMask(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 3000 && len(seen) < 8; i++ {
+		png, err := QRCodePNG(fmt.Sprintf("otpauth://totp/Go%%20Auth:user%d", i), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[decodeTestQRCodePNG(t, png).mask] = true
+	}
+	if len(seen) != 8 {
+		t.Fatalf("only masks %v were chosen", seen)
+	}
+}
+
+func TestQRCodeRejectsTooLong(t *testing.T) {
+	if _, err := QRCodePNG(strings.Repeat("a", qrTestCapacity(40)), 1); err != nil {
+		t.Fatalf("largest payload rejected: %v", err)
+	}
+	if _, err := QRCodePNG(strings.Repeat("a", qrTestCapacity(40)+1), 1); !errors.Is(err, ErrQRCodeTooLong) {
+		t.Fatalf("got %v, want ErrQRCodeTooLong", err)
+	}
+}
+
+// decodeTestQRCodePNG reads a level M byte mode symbol rendered by QRCodePNG,
+// failing the test on anything the specification does not allow
+func decodeTestQRCodePNG(t *testing.T, data []byte) qrTestSymbol {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r < 0x8000
+	}
+
+	// The top left finder pattern starts after the four module quiet zone
+	dim := img.Bounds().Dx()
+	corner := 0
+	for corner < dim && !dark(corner, corner) {
+		corner++
+	}
+	if corner == 0 || corner%4 != 0 {
+		t.Fatalf("symbol starts at pixel %d, not after a four module quiet zone", corner)
+	}
+	scale := corner / 4
+	size := dim/scale - 8
+	if img.Bounds().Dy() != dim || dim != (size+8)*scale || size < 21 || size > 177 || (size-17)%4 != 0 {
+		t.Fatalf("%dx%d pixel image is not a symbol at %d pixels per module", dim, img.Bounds().Dy(), scale)
+	}
+	version := (size - 17) / 4
+
+	for i := 0; i < dim; i++ {
+		for k := 0; k < 4*scale; k++ {
+			if dark(i, k) || dark(k, i) || dark(i, dim-1-k) || dark(dim-1-k, i) {
+				t.Fatalf("quiet zone has a dark pixel")
+			}
+		}
+	}
+
+	grid := make([][]bool, size)
+	for y := range grid {
+		grid[y] = make([]bool, size)
+		for x := range grid[y] {
+			px, py := (x+4)*scale, (y+4)*scale
+			grid[y][x] = dark(px, py)
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					if dark(px+dx, py+dy) != grid[y][x] {
+						t.Fatalf("module %d,%d is not a uniform square", x, y)
+					}
+				}
+			}
+		}
+	}
+	checkTestQRFunctionPatterns(t, grid, version)
+	bit := func(x, y int) int {
+		if grid[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Both copies of the format information
+	var format, formatCopy int
+	for x := 0; x <= 5; x++ {
+		format = format<<1 | bit(x, 8)
+	}
+	format = format<<1 | bit(7, 8)
+	format = format<<1 | bit(8, 8)
+	format = format<<1 | bit(8, 7)
+	for y := 5; y >= 0; y-- {
+		format = format<<1 | bit(8, y)
+	}
+	for y := size - 1; y >= size-7; y-- {
+		formatCopy = formatCopy<<1 | bit(8, y)
+	}
+	for x := size - 8; x < size; x++ {
+		formatCopy = formatCopy<<1 | bit(x, 8)
+	}
+	if format != formatCopy {
+		t.Fatalf("format copies differ: %015b and %015b", format, formatCopy)
+	}
+	mask := -1
+	for m, word := range qrTestFormatM {
+		if word == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format %015b is not a level M format", format)
+	}
+
+	// Both copies of the version information from version 7
+	if version >= 7 {
+		var info, infoCopy int
+		for y := 5; y >= 0; y-- {
+			for x := size - 9; x >= size-11; x-- {
+				info = info<<1 | bit(x, y)
+			}
+		}
+		for x := 5; x >= 0; x-- {
+			for y := size - 9; y >= size-11; y-- {
+				infoCopy = infoCopy<<1 | bit(x, y)
+			}
+		}
+		if info != infoCopy || info>>12 != version || qrTestPolyMod(info, 0x1F25) != 0 {
+			t.Fatalf("version information %018b and %018b do not encode version %d", info, infoCopy, version)
+		}
+	}
+
+	// Unmask the data modules and read them in the zigzag order
+	total := qrTestTotalCodewords[version]
+	function := qrTestFunctionModules(version, size)
+	raw := make([]byte, 0, total+1)
+	var current byte
+	modules := 0
+	up := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for count := 0; count < size; count++ {
+			y := count
+			if up {
+				y = size - 1 - count
+			}
+			for col := 0; col < 2; col++ {
+				x := right - col
+				if function[y][x] {
+					continue
+				}
+				current <<= 1
+				if grid[y][x] != qrTestMasked(mask, x, y) {
+					current |= 1
+				}
+				modules++
+				if modules%8 == 0 {
+					raw = append(raw, current)
+					current = 0
+				}
+			}
+		}
+		up = !up
+	}
+	if modules != total*8+qrTestRemainderBits[version] {
+		t.Fatalf("version %d has %d data modules, want %d", version, modules, total*8+qrTestRemainderBits[version])
+	}
+	raw = raw[:total]
+
+	// Deinterleave the blocks, the longer ones come last
+	numBlocks := qrTestBlocksM[version]
+	ecc := (total - qrTestDataCodewordsM[version]) / numBlocks
+	blockLen := total / numBlocks
+	numLong := total % numBlocks
+	dataLen := func(block int) int {
+		if block >= numBlocks-numLong {
+			return blockLen - ecc + 1
+		}
+		return blockLen - ecc
+	}
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= blockLen-ecc; i++ {
+		for b := range blocks {
+			if i < dataLen(b) {
+				blocks[b] = append(blocks[b], raw[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < ecc; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], raw[k])
+			k++
+		}
+	}
+	var message []byte
+	for b, block := range blocks {
+		if !qrTestValidCodeword(block, ecc) {
+			t.Fatalf("block %d of version %d fails its error correction check", b, version)
+		}
+		message = append(message, block[:dataLen(b)]...)
+	}
+
+	// A single byte mode segment, the terminator and the pad codewords
+	r := &qrTestBitReader{t: t, data: message}
+	if mode := r.read(4); mode != 0x4 {
+		t.Fatalf("mode %04b is not byte mode", mode)
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	text := make([]byte, r.read(countBits))
+	for i := range text {
+		text[i] = byte(r.read(8))
+	}
+	if terminator := qrTestMin(4, r.remaining()); r.read(terminator) != 0 {
+		t.Fatalf("terminator is not zero")
+	}
+	if r.read(r.remaining()%8) != 0 {
+		t.Fatalf("padding to the codeword boundary is not zero")
+	}
+	for pad := 0xEC; r.remaining() > 0; pad ^= 0xEC ^ 0x11 {
+		if got := r.read(8); got != pad {
+			t.Fatalf("pad codeword %#x, want %#x", got, pad)
+		}
+	}
+
+	return qrTestSymbol{text: string(text), version: version, mask: mask}
+}
+
+// checkTestQRFunctionPatterns checks the finder, timing and alignment patterns
+// and the dark module
+func checkTestQRFunctionPatterns(t *testing.T, grid [][]bool, version int) {
+	t.Helper()
+	size := len(grid)
+	for _, centre := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := centre[0]+dx, centre[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				ring := qrTestMax(qrTestAbs(dx), qrTestAbs(dy))
+				if want := ring != 2 && ring != 4; grid[y][x] != want {
+					t.Fatalf("finder pattern module %d,%d is wrong", x, y)
+				}
+			}
+		}
+	}
+	for i := 8; i < size-8; i++ {
+		if grid[6][i] != (i%2 == 0) || grid[i][6] != (i%2 == 0) {
+			t.Fatalf("timing pattern module %d is wrong", i)
+		}
+	}
+	for _, cx := range qrTestAlignmentCentres[version] {
+		for _, cy := range qrTestAlignmentCentres[version] {
+			if qrTestOverlapsFinder(cx, cy, size) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					if want := qrTestMax(qrTestAbs(dx), qrTestAbs(dy)) != 1; grid[cy+dy][cx+dx] != want {
+						t.Fatalf("alignment pattern at %d,%d is wrong", cx, cy)
+					}
+				}
+			}
+		}
+	}
+	if !grid[size-8][8] {
+		t.Fatalf("dark module is light")
+	}
+}
+
+// qrTestFunctionModules marks the modules that carry no data
+func qrTestFunctionModules(version, size int) [][]bool {
+	function := make([][]bool, size)
+	for y := range function {
+		function[y] = make([]bool, size)
+	}
+	mark := func(x0, y0, x1, y1 int) {
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				function[y][x] = true
+			}
+		}
+	}
+
+	// Finder patterns with separators and format information
+	mark(0, 0, 8, 8)
+	mark(size-8, 0, size-1, 8)
+	mark(0, size-8, 8, size-1)
+	// Timing patterns
+	mark(6, 0, 6, size-1)
+	mark(0, 6, size-1, 6)
+	for _, cx := range qrTestAlignmentCentres[version] {
+		for _, cy := range qrTestAlignmentCentres[version] {
+			if !qrTestOverlapsFinder(cx, cy, size) {
+				mark(cx-2, cy-2, cx+2, cy+2)
+			}
+		}
+	}
+	if version >= 7 {
+		mark(size-11, 0, size-9, 5)
+		mark(0, size-11, 5, size-9)
+	}
+	return function
+}
+
+func qrTestOverlapsFinder(x, y, size int) bool {
+	return (x < 9 && y < 9) || (x > size-9 && y < 9) || (x < 9 && y > size-9)
+}
+
+// qrTestMasked reports whether a mask inverts the module in row i, column j
+func qrTestMasked(mask, j, i int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return (i*j)%2+(i*j)%3 == 0
+	case 6:
+		return ((i*j)%2+(i*j)%3)%2 == 0
+	default:
+		return ((i+j)%2+(i*j)%3)%2 == 0
+	}
+}
+
+// qrTestValidCodeword checks that the Reed-Solomon codeword has the roots
+// α^0 to α^(ecc-1) of its generator polynomial
+func qrTestValidCodeword(block []byte, ecc int) bool {
+	var exp [255]int
+	var log [256]int
+	for i, x := 0, 1; i < 255; i++ {
+		exp[i] = x
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for j := 0; j < ecc; j++ {
+		s := 0
+		for _, c := range block {
+			if s != 0 {
+				s = exp[(log[s]+j)%255]
+			}
+			s ^= int(c)
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// qrTestPolyMod returns the remainder of the polynomial division over GF(2)
+func qrTestPolyMod(value, generator int) int {
+	degree := bits.Len(uint(generator))
+	for bits.Len(uint(value)) >= degree {
+		value ^= generator << uint(bits.Len(uint(value))-degree)
+	}
+	return value
+}
+
+type qrTestBitReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *qrTestBitReader) remaining() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *qrTestBitReader) read(n int) int {
+	r.t.Helper()
+	if n > r.remaining() {
+		r.t.Fatalf("data ends %d bits early", n-r.remaining())
+	}
+	value := 0
+	for i := 0; i < n; i++ {
+		value = value<<1 | int(r.data[r.pos>>3]>>(7-uint(r.pos&7))&1)
+		r.pos++
+	}
+	return value
+}
+
+func qrTestAbs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func qrTestMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func qrTestMin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
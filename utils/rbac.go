@@ -0,0 +1,346 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Default roles seeded on first start. Every new user gets RoleUser.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions used by the RBAC admin endpoints
+const (
+	PermissionRBACRead  = "rbac:read"
+	PermissionRBACWrite = "rbac:write"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrRoleProtected      = errors.New("default roles cannot be deleted")
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrPermissionExists   = errors.New("permission already exists")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// RoleDetails is a role together with the names of its permissions
+type RoleDetails struct {
+	models.Role
+	Permissions []string
+}
+
+// RBACStore manages roles, permissions and their assignments
+type RBACStore struct {
+	db *gorm.DB
+}
+
+// NewRBACStore creates a new RBAC store instance
+func NewRBACStore(db *gorm.DB) *RBACStore {
+	return &RBACStore{db: db}
+}
+
+// WithTx returns a store that runs its queries in the given transaction
+func (s *RBACStore) WithTx(tx *gorm.DB) *RBACStore {
+	return &RBACStore{db: tx}
+}
+
+// SeedDefaults creates the default roles and permissions when no role exists
+// yet and gives every existing user the default user role
+func (s *RBACStore) SeedDefaults(ctx context.Context) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Role{}).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count roles: %w", err)
+		}
+		if count > 0 {
+			return nil
+		}
+
+		permissions := []models.Permission{
+			{Name: PermissionRBACRead, Description: "Read roles, permissions and assignments"},
+			{Name: PermissionRBACWrite, Description: "Manage roles, permissions and assignments"},
+		}
+		if err := tx.Create(&permissions).Error; err != nil {
+			return fmt.Errorf("failed to seed permissions: %w", err)
+		}
+
+		user := models.Role{Name: RoleUser, Description: "Default role of every user"}
+		admin := models.Role{Name: RoleAdmin, Description: "Full administrative access"}
+		if err := tx.Create(&[]*models.Role{&user, &admin}).Error; err != nil {
+			return fmt.Errorf("failed to seed roles: %w", err)
+		}
+
+		grants := make([]models.RolePermission, 0, len(permissions))
+		for _, permission := range permissions {
+			grants = append(grants, models.RolePermission{RoleID: admin.ID, PermissionID: permission.ID})
+		}
+		if err := tx.Create(&grants).Error; err != nil {
+			return fmt.Errorf("failed to seed role permissions: %w", err)
+		}
+
+		if err := tx.Exec(
+			"INSERT INTO user_roles (user_id, role_id, created_at) SELECT user_id, ?, ? FROM users WHERE deleted_at IS NULL",
+			user.ID, time.Now(),
+		).Error; err != nil {
+			return fmt.Errorf("failed to assign default role: %w", err)
+		}
+
+		log.Printf("Seeded default roles %s and %s", RoleUser, RoleAdmin)
+		return nil
+	})
+}
+
+// ListRoles returns every role with its permissions
+func (s *RBACStore) ListRoles(ctx context.Context) ([]RoleDetails, error) {
+	var roles []models.Role
+	if err := s.db.WithContext(ctx).Order("name").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	var grants []struct {
+		RoleID uuid.UUID
+		Name   string
+	}
+	if err := s.db.WithContext(ctx).Table("role_permissions").
+		Select("role_permissions.role_id, permissions.name").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Order("permissions.name").
+		Scan(&grants).Error; err != nil {
+		return nil, fmt.Errorf("failed to list role permissions: %w", err)
+	}
+
+	byRole := make(map[uuid.UUID][]string)
+	for _, grant := range grants {
+		byRole[grant.RoleID] = append(byRole[grant.RoleID], grant.Name)
+	}
+
+	details := make([]RoleDetails, 0, len(roles))
+	for _, role := range roles {
+		details = append(details, RoleDetails{Role: role, Permissions: byRole[role.ID]})
+	}
+	return details, nil
+}
+
+// CreateRole creates a role without permissions
+func (s *RBACStore) CreateRole(ctx context.Context, name string, description string) (*models.Role, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.Role{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check role: %w", err)
+	}
+	if count > 0 {
+		return nil, ErrRoleExists
+	}
+
+	role := &models.Role{Name: name, Description: description}
+	if err := s.db.WithContext(ctx).Create(role).Error; err != nil {
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+	return role, nil
+}
+
+// DeleteRole deletes a role together with its grants and assignments
+func (s *RBACStore) DeleteRole(ctx context.Context, roleID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		role, err := findRole(tx, roleID)
+		if err != nil {
+			return err
+		}
+		if role.Name == RoleUser || role.Name == RoleAdmin {
+			return ErrRoleProtected
+		}
+
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+			return fmt.Errorf("failed to delete role permissions: %w", err)
+		}
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.UserRole{}).Error; err != nil {
+			return fmt.Errorf("failed to delete role assignments: %w", err)
+		}
+		return tx.Delete(role).Error
+	})
+}
+
+// ListPermissions returns every permission
+func (s *RBACStore) ListPermissions(ctx context.Context) ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := s.db.WithContext(ctx).Order("name").Find(&permissions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// CreatePermission creates a permission
+func (s *RBACStore) CreatePermission(ctx context.Context, name string, description string) (*models.Permission, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.Permission{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check permission: %w", err)
+	}
+	if count > 0 {
+		return nil, ErrPermissionExists
+	}
+
+	permission := &models.Permission{Name: name, Description: description}
+	if err := s.db.WithContext(ctx).Create(permission).Error; err != nil {
+		return nil, fmt.Errorf("failed to create permission: %w", err)
+	}
+	return permission, nil
+}
+
+// DeletePermission deletes a permission and removes it from every role
+func (s *RBACStore) DeletePermission(ctx context.Context, permissionID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("permission_id = ?", permissionID).Delete(&models.RolePermission{}).Error; err != nil {
+			return fmt.Errorf("failed to delete role permissions: %w", err)
+		}
+		result := tx.Where("id = ?", permissionID).Delete(&models.Permission{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete permission: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrPermissionNotFound
+		}
+		return nil
+	})
+}
+
+// GrantPermission adds a permission to a role
+func (s *RBACStore) GrantPermission(ctx context.Context, roleID uuid.UUID, permissionID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := findRole(tx, roleID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Permission{}).Where("id = ?", permissionID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check permission: %w", err)
+		}
+		if count == 0 {
+			return ErrPermissionNotFound
+		}
+
+		grant := models.RolePermission{RoleID: roleID, PermissionID: permissionID}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&grant).Error
+	})
+}
+
+// RevokePermission removes a permission from a role
+func (s *RBACStore) RevokePermission(ctx context.Context, roleID uuid.UUID, permissionID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("role_id = ? AND permission_id = ?", roleID, permissionID).
+		Delete(&models.RolePermission{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke permission: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPermissionNotFound
+	}
+	return nil
+}
+
+// UserRoles returns the roles assigned to a user
+func (s *RBACStore) UserRoles(ctx context.Context, userID uuid.UUID) ([]models.Role, error) {
+	var roles []models.Role
+	if err := s.db.WithContext(ctx).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+	return roles, nil
+}
+
+// AssignRole gives a role to a user
+func (s *RBACStore) AssignRole(ctx context.Context, userID uuid.UUID, roleID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := findRole(tx, roleID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.User{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check user: %w", err)
+		}
+		if count == 0 {
+			return ErrUserNotFound
+		}
+
+		assignment := models.UserRole{UserID: userID, RoleID: roleID}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignment).Error
+	})
+}
+
+// AssignRoleByName gives the named role to a user
+func (s *RBACStore) AssignRoleByName(ctx context.Context, userID uuid.UUID, name string) error {
+	var role models.Role
+	if err := s.db.WithContext(ctx).Where("name = ?", name).First(&role).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrRoleNotFound
+		}
+		return fmt.Errorf("failed to load role: %w", err)
+	}
+
+	assignment := models.UserRole{UserID: userID, RoleID: role.ID}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&assignment).Error; err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+	return nil
+}
+
+// UnassignRole takes a role away from a user
+func (s *RBACStore) UnassignRole(ctx context.Context, userID uuid.UUID, roleID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("user_id = ? AND role_id = ?", userID, roleID).
+		Delete(&models.UserRole{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to unassign role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// UserAccess returns the names of the user's roles and effective permissions,
+// which are embedded in the access tokens issued to the user
+func (s *RBACStore) UserAccess(ctx context.Context, userID uuid.UUID) ([]string, []string, error) {
+	var roles []string
+	if err := s.db.WithContext(ctx).Table("user_roles").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &roles).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve user roles: %w", err)
+	}
+
+	var permissions []string
+	if err := s.db.WithContext(ctx).Table("user_roles").
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("user_roles.user_id = ?", userID).
+		Distinct().
+		Order("permissions.name").
+		Pluck("permissions.name", &permissions).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve user permissions: %w", err)
+	}
+	return roles, permissions, nil
+}
+
+func findRole(tx *gorm.DB, roleID uuid.UUID) (*models.Role, error) {
+	var role models.Role
+	if err := tx.First(&role, "id = ?", roleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to load role: %w", err)
+	}
+	return &role, nil
+}
@@ -2,14 +2,19 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 	"github.com/HersheyPlus/go-auth/models"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found or expired")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+)
 
 // TokenStore handles refresh token operations
 type TokenStore struct {
@@ -21,27 +26,9 @@ func NewTokenStore(db *gorm.DB) *TokenStore {
     return &TokenStore{db: db}
 }
 
-// StoreToken stores a refresh token in the database
-func (s *TokenStore) StoreToken(ctx context.Context, userID uuid.UUID, tokenDetails *TokenDetails) error {
-    refreshToken := models.RefreshToken{
-        UserID:    userID,
-        TokenUUID: tokenDetails.RefreshUuid,
-        ExpiresAt: tokenDetails.RtExpires,
-    }
-
-    return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-        // Optionally delete existing refresh tokens for the user
-        if err := tx.Where("user_id = ?", userID).Delete(&models.RefreshToken{}).Error; err != nil {
-            return fmt.Errorf("failed to delete existing tokens: %w", err)
-        }
-
-        // Store new refresh token
-        if err := tx.Create(&refreshToken).Error; err != nil {
-            return fmt.Errorf("failed to store refresh token: %w", err)
-        }
-
-        return nil
-    })
+// WithTx returns a token store bound to an existing transaction
+func (s *TokenStore) WithTx(tx *gorm.DB) *TokenStore {
+    return &TokenStore{db: tx}
 }
 
 // DeleteToken removes a refresh token from the database
@@ -57,14 +44,14 @@ func (s *TokenStore) DeleteToken(ctx context.Context, tokenUUID string) error {
 func (s *TokenStore) ValidateToken(ctx context.Context, tokenUUID string) (uuid.UUID, error) {
     var token models.RefreshToken
     err := s.db.WithContext(ctx).Where(
-        "token_uuid = ? AND expires_at > ?", 
+        "token_uuid = ? AND expires_at > ? AND revoked_at IS NULL", 
         tokenUUID, 
         time.Now(),
     ).First(&token).Error
 
     if err != nil {
         if err == gorm.ErrRecordNotFound {
-            return uuid.Nil, fmt.Errorf("token not found or expired")
+            return uuid.Nil, ErrRefreshTokenNotFound
         }
         return uuid.Nil, fmt.Errorf("failed to validate token: %w", err)
     }
@@ -72,6 +59,96 @@ func (s *TokenStore) ValidateToken(ctx context.Context, tokenUUID string) (uuid.
     return token.UserID, nil
 }
 
+// RotateToken revokes the presented refresh token and stores its replacement in
+// the same family. Presenting a token that was already rotated revokes the whole
+// family and returns ErrRefreshTokenReused.
+func (s *TokenStore) RotateToken(ctx context.Context, tokenUUID string, userID uuid.UUID, tokenDetails *TokenDetails) error {
+    reused := false
+    err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        var current models.RefreshToken
+        err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("token_uuid = ? AND user_id = ?", tokenUUID, userID).
+            First(&current).Error
+        if err != nil {
+            if err == gorm.ErrRecordNotFound {
+                return ErrRefreshTokenNotFound
+            }
+            return fmt.Errorf("failed to load refresh token: %w", err)
+        }
+
+        now := time.Now()
+        if current.RevokedAt != nil {
+            // Commit the family revocation and report the reuse afterwards
+            reused = true
+            return s.revokeFamily(tx, current.FamilyID, now)
+        }
+
+        if !current.ExpiresAt.After(now) {
+            return ErrRefreshTokenNotFound
+        }
+
+        if err := tx.Model(&current).Updates(map[string]interface{}{
+            "revoked_at":  now,
+            "replaced_by": tokenDetails.RefreshUuid,
+        }).Error; err != nil {
+            return fmt.Errorf("failed to revoke refresh token: %w", err)
+        }
+
+        next := models.RefreshToken{
+            UserID:    userID,
+            TokenUUID: tokenDetails.RefreshUuid,
+            FamilyID:  current.FamilyID,
+            ExpiresAt: tokenDetails.RtExpires,
+        }
+        if err := tx.Create(&next).Error; err != nil {
+            return fmt.Errorf("failed to store refresh token: %w", err)
+        }
+
+        if err := tx.Model(&models.Session{}).Where("id = ?", current.FamilyID).
+            Update("last_used_at", now).Error; err != nil {
+            return fmt.Errorf("failed to update session: %w", err)
+        }
+
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    if reused {
+        return ErrRefreshTokenReused
+    }
+    return nil
+}
+
+// RevokeTokenFamily revokes the refresh token, every token rotated from the
+// same login and the session they belong to
+func (s *TokenStore) RevokeTokenFamily(ctx context.Context, tokenUUID string) error {
+    return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        var token models.RefreshToken
+        if err := tx.Where("token_uuid = ?", tokenUUID).First(&token).Error; err != nil {
+            if err == gorm.ErrRecordNotFound {
+                return ErrRefreshTokenNotFound
+            }
+            return fmt.Errorf("failed to load refresh token: %w", err)
+        }
+        return s.revokeFamily(tx, token.FamilyID, time.Now())
+    })
+}
+
+// revokeFamily revokes every still-active token that belongs to the family
+// and ends the session it was issued for
+func (s *TokenStore) revokeFamily(tx *gorm.DB, familyID uuid.UUID, at time.Time) error {
+    if err := tx.Model(&models.RefreshToken{}).
+        Where("family_id = ? AND revoked_at IS NULL", familyID).
+        Update("revoked_at", at).Error; err != nil {
+        return fmt.Errorf("failed to revoke token family: %w", err)
+    }
+    if err := tx.Where("id = ?", familyID).Delete(&models.Session{}).Error; err != nil {
+        return fmt.Errorf("failed to delete session: %w", err)
+    }
+    return nil
+}
+
 // CleanupExpiredTokens removes expired refresh tokens
 func (s *TokenStore) CleanupExpiredTokens(ctx context.Context) error {
     return s.db.WithContext(ctx).
@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore keeps revoked access token IDs and per-user cutoffs so that
+// access tokens can be invalidated before they expire
+type RevocationStore interface {
+	// RevokeToken denylists a single token until it expires
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsTokenRevoked reports whether the token ID is denylisted
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUserTokens revokes every token of the user issued before the given
+	// time, truncated to the second of the iat claim
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error
+	// UserTokensRevokedBefore returns the user's cutoff, or the zero time if none
+	UserTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error)
+}
+
+// NewRevocationStore creates the store selected in the config, wrapped in a
+// short-lived local cache when a cache TTL is configured
+func NewRevocationStore(db *gorm.DB, cfg *config.RevocationConfig) RevocationStore {
+	var store RevocationStore
+	switch cfg.Store {
+	case "memory":
+		store = NewMemoryRevocationStore()
+	default:
+		store = NewPostgresRevocationStore(db)
+	}
+
+	if cfg.CacheTTL > 0 {
+		store = NewCachedRevocationStore(store, cfg.CacheTTL)
+	}
+	return store
+}
+
+// TokenRevoked reports whether the token was revoked by ID or by a user cutoff
+func TokenRevoked(ctx context.Context, store RevocationStore, claims *Claims) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := store.IsTokenRevoked(ctx, claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	if claims.SessionID != "" {
+		revoked, err := store.IsTokenRevoked(ctx, sessionRevocationKey(claims.SessionID))
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return false, nil
+	}
+	cutoff, err := store.UserTokensRevokedBefore(ctx, userID)
+	if err != nil || cutoff.IsZero() {
+		return false, err
+	}
+	// iat only has second precision and the cutoff is truncated to match, so
+	// the tokens issued along with the cutoff, by the login or password change
+	// that set it, survive it
+	if claims.IssuedAt == nil {
+		return true, nil
+	}
+	return claims.IssuedAt.Time.Before(cutoff), nil
+}
+
+// userTokensCutoff is the cutoff stored for RevokeUserTokens
+func userTokensCutoff(before time.Time) time.Time {
+	return before.Truncate(time.Second)
+}
+
+// RevokeSessionTokens denylists every token bound to the session through its
+// sid claim. The entry is kept until expiresAt, when the last access token
+// issued for the session has expired.
+func RevokeSessionTokens(ctx context.Context, store RevocationStore, sessionID uuid.UUID, expiresAt time.Time) error {
+	return store.RevokeToken(ctx, sessionRevocationKey(sessionID.String()), expiresAt)
+}
+
+// sessionRevocationKey is the denylist entry of a revoked session, kept apart
+// from token IDs by its prefix
+func sessionRevocationKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+// MemoryRevocationStore keeps revocations in process memory. It is only
+// suitable for a single instance and loses its state on restart.
+type MemoryRevocationStore struct {
+	mu      sync.RWMutex
+	tokens  map[string]time.Time
+	cutoffs map[uuid.UUID]time.Time
+}
+
+// NewMemoryRevocationStore creates an empty in-memory revocation store
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:  make(map[string]time.Time),
+		cutoffs: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop entries whose tokens have expired anyway
+	now := time.Now()
+	for id, expiry := range s.tokens {
+		if expiry.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiry, ok := s.tokens[jti]
+	return ok && expiry.After(time.Now()), nil
+}
+
+func (s *MemoryRevocationStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cutoffs[userID] = userTokensCutoff(before)
+	return nil
+}
+
+func (s *MemoryRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cutoffs[userID], nil
+}
+
+// PostgresRevocationStore keeps revocations in the database so that they are
+// shared by every instance
+type PostgresRevocationStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresRevocationStore creates a new database backed revocation store
+func NewPostgresRevocationStore(db *gorm.DB) *PostgresRevocationStore {
+	return &PostgresRevocationStore{db: db}
+}
+
+func (s *PostgresRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Drop entries whose tokens have expired anyway
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+			return fmt.Errorf("failed to clean up revoked tokens: %w", err)
+		}
+
+		revoked := models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return fmt.Errorf("failed to revoke token: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *PostgresRevocationStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.RevokedToken{}).
+		Where("jti = ? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *PostgresRevocationStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	cutoff := models.TokenCutoff{
+		UserID:        userID,
+		RevokedBefore: userTokensCutoff(before),
+		UpdatedAt:     time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "updated_at"}),
+	}).Create(&cutoff).Error; err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var cutoff models.TokenCutoff
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Limit(1).Find(&cutoff).Error
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check token cutoff: %w", err)
+	}
+	return cutoff.RevokedBefore, nil
+}
+
+// CachedRevocationStore answers lookups from a local cache for a short TTL.
+// Revocations made through it are visible immediately on this instance and
+// after at most one TTL on the others.
+type CachedRevocationStore struct {
+	next      RevocationStore
+	ttl       time.Duration
+	mu        sync.Mutex
+	tokens    map[string]cachedValue[bool]
+	cutoffs   map[uuid.UUID]cachedValue[time.Time]
+	lastSweep time.Time
+}
+
+type cachedValue[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// NewCachedRevocationStore wraps a store with a local cache
+func NewCachedRevocationStore(next RevocationStore, ttl time.Duration) *CachedRevocationStore {
+	return &CachedRevocationStore{
+		next:    next,
+		ttl:     ttl,
+		tokens:  make(map[string]cachedValue[bool]),
+		cutoffs: make(map[uuid.UUID]cachedValue[time.Time]),
+	}
+}
+
+func (s *CachedRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := s.next.RevokeToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tokens[jti] = cachedValue[bool]{value: true, expires: expiresAt}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *CachedRevocationStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.tokens[jti]
+	s.mu.Unlock()
+	if ok && entry.expires.After(now) {
+		return entry.value, nil
+	}
+
+	revoked, err := s.next.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.evictExpired(now)
+	s.tokens[jti] = cachedValue[bool]{value: revoked, expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+func (s *CachedRevocationStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	if err := s.next.RevokeUserTokens(ctx, userID, before); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cutoffs[userID] = cachedValue[time.Time]{value: userTokensCutoff(before), expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *CachedRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.cutoffs[userID]
+	s.mu.Unlock()
+	if ok && entry.expires.After(now) {
+		return entry.value, nil
+	}
+
+	cutoff, err := s.next.UserTokensRevokedBefore(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	s.evictExpired(now)
+	s.cutoffs[userID] = cachedValue[time.Time]{value: cutoff, expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+	return cutoff, nil
+}
+
+// evictExpired drops stale cache entries at most once per TTL, the caller
+// must hold the lock
+func (s *CachedRevocationStore) evictExpired(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+
+	for jti, entry := range s.tokens {
+		if !entry.expires.After(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.cutoffs {
+		if !entry.expires.After(now) {
+			delete(s.cutoffs, userID)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionInfo describes the device a session was created from
+type SessionInfo struct {
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+}
+
+// CreateSession stores a new session together with its first refresh token.
+// When maxSessions is positive the oldest sessions above the cap are evicted.
+func (s *TokenStore) CreateSession(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID, info SessionInfo, tokenDetails *TokenDetails, maxSessions int) error {
+	now := time.Now()
+	session := models.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		DeviceName: truncate(info.DeviceName, 100),
+		UserAgent:  truncate(info.UserAgent, 255),
+		IPAddress:  truncate(info.IPAddress, 45),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	refreshToken := models.RefreshToken{
+		UserID:    userID,
+		TokenUUID: tokenDetails.RefreshUuid,
+		FamilyID:  sessionID,
+		ExpiresAt: tokenDetails.RtExpires,
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		if err := tx.Create(&refreshToken).Error; err != nil {
+			return fmt.Errorf("failed to store refresh token: %w", err)
+		}
+
+		if maxSessions <= 0 {
+			return nil
+		}
+
+		// Evict the oldest sessions above the per-user cap
+		var stale []uuid.UUID
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ?", userID).
+			Order("created_at DESC").
+			Offset(maxSessions).
+			Pluck("id", &stale).Error; err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+		return deleteSessions(tx, stale)
+	})
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *TokenStore) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a single session of a user and deletes its refresh tokens
+func (s *TokenStore) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&models.Session{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete session: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrSessionNotFound
+		}
+		if err := tx.Where("family_id = ?", sessionID).Delete(&models.RefreshToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %w", err)
+		}
+		return nil
+	})
+}
+
+// RevokeOtherSessions ends every session of a user except the given one and
+// returns the IDs of the sessions revoked
+func (s *TokenStore) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepSessionID uuid.UUID) ([]uuid.UUID, error) {
+	var revoked []uuid.UUID
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ?", userID, keepSessionID).
+			Pluck("id", &revoked).Error; err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+		return deleteSessions(tx, revoked)
+	})
+	return revoked, err
+}
+
+// RevokeAllSessions ends every session of a user and deletes all refresh tokens
+func (s *TokenStore) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RefreshToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error; err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+// deleteSessions removes the given sessions and all refresh tokens issued for them
+func deleteSessions(tx *gorm.DB, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Where("family_id IN ?", ids).Delete(&models.RefreshToken{}).Error; err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&models.Session{}).Error; err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
@@ -0,0 +1,499 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebAuthn ceremonies a session can be started for
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login" // passwordless login with a discoverable credential
+	CeremonySecondFactor = "mfa"   // second factor after the password
+)
+
+// COSE algorithms accepted for credentials, in order of preference
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// SupportedCOSEAlgorithms is offered to authenticators during registration
+var SupportedCOSEAlgorithms = []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256}
+
+// Authenticator data flags (WebAuthn section 6.1)
+const (
+	authDataUserPresent      = 0x01
+	authDataUserVerified     = 0x04
+	authDataBackedUp         = 0x10
+	authDataAttestedCredData = 0x40
+	authDataExtensions       = 0x80
+)
+
+var (
+	ErrWebAuthnSessionInvalid = errors.New("webauthn ceremony is invalid or expired")
+	ErrWebAuthnVerification   = errors.New("webauthn response could not be verified")
+	ErrCredentialNotFound     = errors.New("credential not found")
+	ErrCredentialExists       = errors.New("credential is already registered")
+)
+
+// WebAuthnStore runs WebAuthn ceremonies and stores the registered credentials
+type WebAuthnStore struct {
+	db  *gorm.DB
+	cfg *config.WebAuthnConfig
+}
+
+// NewWebAuthnStore creates a new WebAuthn store instance
+func NewWebAuthnStore(db *gorm.DB, cfg *config.WebAuthnConfig) *WebAuthnStore {
+	return &WebAuthnStore{db: db, cfg: cfg}
+}
+
+// RegistrationResponse is the authenticator's answer to a registration
+type RegistrationResponse struct {
+	ClientDataJSON    []byte
+	AttestationObject []byte
+	Transports        []string
+}
+
+// AssertionResponse is the authenticator's answer to an authentication
+type AssertionResponse struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+// collectedClientData is the client data the browser signs over (WebAuthn
+// section 5.8.1)
+type collectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the parsed authenticator data (WebAuthn section 6.1)
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       uuid.UUID
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// BeginCeremony starts a ceremony and returns the session holding its
+// challenge. userID is nil for passwordless logins.
+func (s *WebAuthnStore) BeginCeremony(ctx context.Context, ceremony string, userID *uuid.UUID) (*models.WebAuthnSession, error) {
+	challenge, err := GenerateSecret(32)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.WebAuthnSession{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(s.cfg.ChallengeTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webauthn session: %w", err)
+	}
+	return session, nil
+}
+
+// FinishRegistration verifies the authenticator's response to a registration
+// session of the user and stores the new credential. Attestation statements
+// are recorded but not verified, as registration requests no attestation.
+func (s *WebAuthnStore) FinishRegistration(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID, name string, resp *RegistrationResponse) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeSession(ctx, sessionID, CeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != userID {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+
+	authData, format, err := s.verifyRegistration(session, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential *models.WebAuthnCredential
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", authData.CredentialID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check credential: %w", err)
+		}
+		if count > 0 {
+			return ErrCredentialExists
+		}
+
+		credential = &models.WebAuthnCredential{
+			UserID:            userID,
+			Name:              name,
+			CredentialID:      authData.CredentialID,
+			PublicKey:         authData.PublicKey,
+			SignCount:         int64(authData.SignCount),
+			Transports:        resp.Transports,
+			AttestationFormat: format,
+			AAGUID:            authData.AAGUID,
+			BackedUp:          authData.Flags&authDataBackedUp != 0,
+		}
+		if err := tx.Create(credential).Error; err != nil {
+			return fmt.Errorf("failed to store credential: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// FinishAssertion verifies the authenticator's response to an authentication
+// session and returns the credential that signed it. Sessions started for a
+// user only accept that user's credentials. User verification is required for
+// passwordless logins, where the credential is the only factor.
+func (s *WebAuthnStore) FinishAssertion(ctx context.Context, sessionID uuid.UUID, ceremony string, resp *AssertionResponse) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeSession(ctx, sessionID, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential models.WebAuthnCredential
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("credential_id = ?", resp.CredentialID).
+			First(&credential).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrCredentialNotFound
+			}
+			return fmt.Errorf("failed to load credential: %w", err)
+		}
+
+		authData, err := s.verifyAssertion(session, &credential, resp)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&credential).Updates(map[string]interface{}{
+			"sign_count":   int64(authData.SignCount),
+			"backed_up":    authData.Flags&authDataBackedUp != 0,
+			"last_used_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// verifyRegistration checks a registration response against its session and
+// returns the authenticator data and attestation format of the new credential
+func (s *WebAuthnStore) verifyRegistration(session *models.WebAuthnSession, resp *RegistrationResponse) (*authenticatorData, string, error) {
+	if err := s.verifyClientData(resp.ClientDataJSON, "webauthn.create", session.Challenge); err != nil {
+		return nil, "", err
+	}
+
+	decoded, _, err := decodeCBOR(resp.AttestationObject)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: attestation object: %v", ErrWebAuthnVerification, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("%w: attestation object is not a map", ErrWebAuthnVerification)
+	}
+	format, _ := attestation["fmt"].(string)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if format == "" || rawAuthData == nil {
+		return nil, "", fmt.Errorf("%w: attestation object is incomplete", ErrWebAuthnVerification)
+	}
+
+	authData, err := s.verifyAuthenticatorData(rawAuthData, false)
+	if err != nil {
+		return nil, "", err
+	}
+	if authData.Flags&authDataAttestedCredData == 0 {
+		return nil, "", fmt.Errorf("%w: no attested credential data", ErrWebAuthnVerification)
+	}
+	if _, err := parseCOSEKey(authData.PublicKey); err != nil {
+		return nil, "", err
+	}
+	return authData, format, nil
+}
+
+// verifyAssertion checks an assertion response against its session and the
+// stored credential it names, and returns the authenticator data
+func (s *WebAuthnStore) verifyAssertion(session *models.WebAuthnSession, credential *models.WebAuthnCredential, resp *AssertionResponse) (*authenticatorData, error) {
+	if session.UserID != nil && *session.UserID != credential.UserID {
+		return nil, ErrCredentialNotFound
+	}
+	if len(resp.UserHandle) > 0 && !bytes.Equal(resp.UserHandle, credential.UserID[:]) {
+		return nil, fmt.Errorf("%w: user handle does not match the credential", ErrWebAuthnVerification)
+	}
+
+	if err := s.verifyClientData(resp.ClientDataJSON, "webauthn.get", session.Challenge); err != nil {
+		return nil, err
+	}
+	authData, err := s.verifyAuthenticatorData(resp.AuthenticatorData, session.Ceremony == CeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.AuthenticatorData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(credential.PublicKey, signed, resp.Signature); err != nil {
+		return nil, err
+	}
+
+	// A counter that does not increase suggests a cloned authenticator.
+	// Authenticators without a counter always report zero.
+	if (authData.SignCount != 0 || credential.SignCount != 0) && int64(authData.SignCount) <= credential.SignCount {
+		return nil, fmt.Errorf("%w: signature counter did not increase", ErrWebAuthnVerification)
+	}
+	return authData, nil
+}
+
+// ListCredentials returns the user's credentials
+func (s *WebAuthnStore) ListCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("failed to list credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// HasCredentials reports whether the user registered any credential
+func (s *WebAuthnStore) HasCredentials(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.WebAuthnCredential{}).
+		Where("user_id = ?", userID).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check credentials: %w", err)
+	}
+	return count > 0, nil
+}
+
+// DeleteCredential removes one of the user's credentials
+func (s *WebAuthnStore) DeleteCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete credential: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+// CleanupExpiredSessions removes ceremonies that were never finished
+func (s *WebAuthnStore) CleanupExpiredSessions(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.WebAuthnSession{}).Error
+}
+
+// consumeSession deletes a live session of the ceremony and returns it. The
+// session is gone even if the response then fails verification.
+func (s *WebAuthnStore) consumeSession(ctx context.Context, sessionID uuid.UUID, ceremony string) (*models.WebAuthnSession, error) {
+	var sessions []models.WebAuthnSession
+	result := s.db.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("id = ? AND ceremony = ? AND expires_at > ?", sessionID, ceremony, time.Now()).
+		Delete(&sessions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume webauthn session: %w", result.Error)
+	}
+	if len(sessions) == 0 {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+	return &sessions[0], nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin the browser
+// recorded
+func (s *WebAuthnStore) verifyClientData(raw []byte, ceremonyType string, challenge string) error {
+	var clientData collectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return fmt.Errorf("%w: client data: %v", ErrWebAuthnVerification, err)
+	}
+	if clientData.Type != ceremonyType {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrWebAuthnVerification, clientData.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(clientData.Challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrWebAuthnVerification)
+	}
+	for _, origin := range s.cfg.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %q is not allowed", ErrWebAuthnVerification, clientData.Origin)
+}
+
+// verifyAuthenticatorData parses the authenticator data and checks the relying
+// party and the user presence and verification flags
+func (s *WebAuthnStore) verifyAuthenticatorData(raw []byte, requireUserVerification bool) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rpIDHash := sha256.Sum256([]byte(s.cfg.RPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: relying party id mismatch", ErrWebAuthnVerification)
+	}
+	if authData.Flags&authDataUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrWebAuthnVerification)
+	}
+	if requireUserVerification && authData.Flags&authDataUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrWebAuthnVerification)
+	}
+	return authData, nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrWebAuthnVerification)
+	}
+
+	authData := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if authData.Flags&authDataAttestedCredData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrWebAuthnVerification)
+		}
+		copy(authData.AAGUID[:], rest[:16])
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: invalid credential id", ErrWebAuthnVerification)
+		}
+		authData.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		var err error
+		remaining := rest
+		if _, remaining, err = decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("%w: credential public key: %v", ErrWebAuthnVerification, err)
+		}
+		authData.PublicKey = rest[:len(rest)-len(remaining)]
+		rest = remaining
+	}
+
+	if authData.Flags&authDataExtensions != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("%w: extensions: %v", ErrWebAuthnVerification, err)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrWebAuthnVerification)
+	}
+	return authData, nil
+}
+
+// coseKey is a parsed credential public key (RFC 9053)
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: credential public key: %v", ErrWebAuthnVerification, err)
+	}
+	params, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: credential public key is not a map", ErrWebAuthnVerification)
+	}
+
+	kty, _ := params[int64(1)].(int64)
+	alg, _ := params[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			break
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			break
+		}
+		return &coseKey{alg: alg, key: key}, nil
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			break
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			break
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported credential public key (kty %d, alg %d)", ErrWebAuthnVerification, kty, alg)
+}
+
+// verifyCOSESignature verifies an assertion signature with the stored key
+func verifyCOSESignature(rawKey []byte, data []byte, signature []byte) error {
+	key, err := parseCOSEKey(rawKey)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(data)
+	var valid bool
+	switch pub := key.key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, data, signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return fmt.Errorf("%w: invalid signature", ErrWebAuthnVerification)
+	}
+	return nil
+}
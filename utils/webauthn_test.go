@@ -0,0 +1,605 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+func newTestWebAuthnStore() *WebAuthnStore {
+	return NewWebAuthnStore(nil, &config.WebAuthnConfig{
+		RPID:         testRPID,
+		Origins:      []string{testOrigin},
+		ChallengeTTL: time.Minute,
+	})
+}
+
+func newTestSession(ceremony string, userID *uuid.UUID) *models.WebAuthnSession {
+	challenge, err := GenerateSecret(32)
+	if err != nil {
+		panic(err)
+	}
+	return &models.WebAuthnSession{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+}
+
+// softAuthenticator is an authenticator in software, producing the responses
+// a browser would pass on
+type softAuthenticator struct {
+	alg          int64
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	credentialID []byte
+	rpID         string
+	origin       string
+	flags        byte
+	signCount    uint32
+	noCounter    bool // reports a zero counter, like most passkey providers
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	t.Helper()
+	a := &softAuthenticator{
+		alg:          alg,
+		credentialID: make([]byte, 16),
+		rpID:         testRPID,
+		origin:       testOrigin,
+		flags:        authDataUserPresent | authDataUserVerified,
+	}
+	if _, err := rand.Read(a.credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	switch alg {
+	case COSEAlgES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case COSEAlgEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.alg == COSEAlgEdDSA {
+		return encodeCBOR(cborMap{
+			{int64(1), int64(1)},
+			{int64(3), int64(COSEAlgEdDSA)},
+			{int64(-1), int64(6)},
+			{int64(-2), []byte(a.edKey.Public().(ed25519.PublicKey))},
+		})
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return encodeCBOR(cborMap{
+		{int64(1), int64(2)},
+		{int64(3), int64(COSEAlgES256)},
+		{int64(-1), int64(1)},
+		{int64(-2), x},
+		{int64(-3), y},
+	})
+}
+
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= authDataAttestedCredData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) clientData(ceremonyType string, challenge string) []byte {
+	raw, err := json.Marshal(collectedClientData{Type: ceremonyType, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func (a *softAuthenticator) register(challenge string) *RegistrationResponse {
+	return &RegistrationResponse{
+		ClientDataJSON: a.clientData("webauthn.create", challenge),
+		AttestationObject: encodeCBOR(cborMap{
+			{"fmt", "none"},
+			{"attStmt", cborMap{}},
+			{"authData", a.authenticatorData(true)},
+		}),
+	}
+}
+
+func (a *softAuthenticator) assert(t *testing.T, challenge string) *AssertionResponse {
+	t.Helper()
+	if !a.noCounter {
+		a.signCount++
+	}
+	resp := &AssertionResponse{
+		CredentialID:      a.credentialID,
+		ClientDataJSON:    a.clientData("webauthn.get", challenge),
+		AuthenticatorData: a.authenticatorData(false),
+	}
+
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.AuthenticatorData...), clientDataHash[:]...)
+	if a.alg == COSEAlgEdDSA {
+		resp.Signature = ed25519.Sign(a.edKey, signed)
+		return resp
+	}
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Signature = signature
+	return resp
+}
+
+// registerSoftAuthenticator registers the authenticator for the user and
+// returns the credential as it would be stored
+func registerSoftAuthenticator(t *testing.T, s *WebAuthnStore, a *softAuthenticator, userID uuid.UUID) *models.WebAuthnCredential {
+	t.Helper()
+	session := newTestSession(CeremonyRegistration, &userID)
+	authData, _, err := s.verifyRegistration(session, a.register(session.Challenge))
+	if err != nil {
+		t.Fatalf("registration rejected: %v", err)
+	}
+	return &models.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    int64(authData.SignCount),
+	}
+}
+
+func TestWebAuthnRoundTrip(t *testing.T) {
+	for name, alg := range map[string]int64{"ES256": COSEAlgES256, "EdDSA": COSEAlgEdDSA} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestWebAuthnStore()
+			a := newSoftAuthenticator(t, alg)
+			userID := uuid.New()
+
+			session := newTestSession(CeremonyRegistration, &userID)
+			authData, format, err := s.verifyRegistration(session, a.register(session.Challenge))
+			if err != nil {
+				t.Fatalf("registration rejected: %v", err)
+			}
+			if format != "none" || string(authData.CredentialID) != string(a.credentialID) {
+				t.Fatalf("unexpected registration result: format %q, credential %x", format, authData.CredentialID)
+			}
+			credential := &models.WebAuthnCredential{UserID: userID, CredentialID: authData.CredentialID, PublicKey: authData.PublicKey}
+
+			for _, ceremony := range []string{CeremonySecondFactor, CeremonyLogin} {
+				session := newTestSession(ceremony, &userID)
+				authData, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge))
+				if err != nil {
+					t.Fatalf("%s assertion rejected: %v", ceremony, err)
+				}
+				if authData.SignCount != a.signCount {
+					t.Fatalf("sign count = %d, want %d", authData.SignCount, a.signCount)
+				}
+				credential.SignCount = int64(authData.SignCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnAssertionRejectsOtherUser(t *testing.T) {
+	s := newTestWebAuthnStore()
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	credential := registerSoftAuthenticator(t, s, a, uuid.New())
+
+	otherUser := uuid.New()
+	session := newTestSession(CeremonySecondFactor, &otherUser)
+	if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("assertion for another user's session: got %v, want ErrCredentialNotFound", err)
+	}
+
+	session = newTestSession(CeremonyLogin, nil)
+	resp := a.assert(t, session.Challenge)
+	resp.UserHandle = otherUser[:]
+	if _, err := s.verifyAssertion(session, credential, resp); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("assertion with another user handle: got %v, want ErrWebAuthnVerification", err)
+	}
+}
+
+func TestWebAuthnAssertionRejectsSignCountRegression(t *testing.T) {
+	s := newTestWebAuthnStore()
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	userID := uuid.New()
+	credential := registerSoftAuthenticator(t, s, a, userID)
+	credential.SignCount = 10
+
+	// The authenticator's counter is below the stored one, then equal to it
+	for _, count := range []uint32{4, 9} {
+		a.signCount = count
+		session := newTestSession(CeremonySecondFactor, &userID)
+		if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); !errors.Is(err, ErrWebAuthnVerification) {
+			t.Fatalf("sign count %d after %d: got %v, want ErrWebAuthnVerification", a.signCount, credential.SignCount, err)
+		}
+	}
+
+	session := newTestSession(CeremonySecondFactor, &userID)
+	if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); err != nil {
+		t.Fatalf("increasing sign count rejected: %v", err)
+	}
+}
+
+func TestWebAuthnAssertionAllowsAuthenticatorWithoutCounter(t *testing.T) {
+	s := newTestWebAuthnStore()
+	a := newSoftAuthenticator(t, COSEAlgEdDSA)
+	userID := uuid.New()
+	credential := registerSoftAuthenticator(t, s, a, userID)
+
+	a.noCounter = true
+	for i := 0; i < 2; i++ {
+		session := newTestSession(CeremonySecondFactor, &userID)
+		if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); err != nil {
+			t.Fatalf("assertion without counter rejected: %v", err)
+		}
+	}
+}
+
+func TestWebAuthnRejectsWrongRelyingParty(t *testing.T) {
+	s := newTestWebAuthnStore()
+	userID := uuid.New()
+
+	tests := []struct {
+		name   string
+		rpID   string
+		origin string
+	}{
+		{"origin", testRPID, "https://evil.example"},
+		{"origin scheme", testRPID, "http://example.com"},
+		{"rp id", "evil.example", testOrigin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t, COSEAlgES256)
+			credential := registerSoftAuthenticator(t, s, a, userID)
+			a.rpID, a.origin = tt.rpID, tt.origin
+
+			session := newTestSession(CeremonyRegistration, &userID)
+			if _, _, err := s.verifyRegistration(session, a.register(session.Challenge)); !errors.Is(err, ErrWebAuthnVerification) {
+				t.Fatalf("registration: got %v, want ErrWebAuthnVerification", err)
+			}
+			session = newTestSession(CeremonySecondFactor, &userID)
+			if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); !errors.Is(err, ErrWebAuthnVerification) {
+				t.Fatalf("assertion: got %v, want ErrWebAuthnVerification", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnPasswordlessLoginRequiresUserVerification(t *testing.T) {
+	s := newTestWebAuthnStore()
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	userID := uuid.New()
+	credential := registerSoftAuthenticator(t, s, a, userID)
+	a.flags = authDataUserPresent
+
+	session := newTestSession(CeremonyLogin, nil)
+	if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("passwordless login without user verification: got %v, want ErrWebAuthnVerification", err)
+	}
+
+	// After the password, presence alone is enough
+	session = newTestSession(CeremonySecondFactor, &userID)
+	if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); err != nil {
+		t.Fatalf("second factor without user verification rejected: %v", err)
+	}
+
+	a.flags = 0
+	session = newTestSession(CeremonySecondFactor, &userID)
+	if _, err := s.verifyAssertion(session, credential, a.assert(t, session.Challenge)); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("assertion without user presence: got %v, want ErrWebAuthnVerification", err)
+	}
+}
+
+func TestWebAuthnRejectsReplayedAssertion(t *testing.T) {
+	s := newTestWebAuthnStore()
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	userID := uuid.New()
+	credential := registerSoftAuthenticator(t, s, a, userID)
+
+	session := newTestSession(CeremonySecondFactor, &userID)
+	resp := a.assert(t, session.Challenge)
+	authData, err := s.verifyAssertion(session, credential, resp)
+	if err != nil {
+		t.Fatalf("assertion rejected: %v", err)
+	}
+	credential.SignCount = int64(authData.SignCount)
+
+	// Against a new challenge, and against the same one once the counter moved
+	if _, err := s.verifyAssertion(newTestSession(CeremonySecondFactor, &userID), credential, resp); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("assertion replayed on a new challenge: got %v, want ErrWebAuthnVerification", err)
+	}
+	if _, err := s.verifyAssertion(session, credential, resp); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("assertion replayed on its challenge: got %v, want ErrWebAuthnVerification", err)
+	}
+
+	// A registration response does not answer an authentication
+	registration := a.register(session.Challenge)
+	resp.ClientDataJSON = registration.ClientDataJSON
+	if _, err := s.verifyAssertion(session, credential, resp); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("registration client data accepted for an assertion: got %v, want ErrWebAuthnVerification", err)
+	}
+}
+
+func TestWebAuthnSessionIsConsumedOnce(t *testing.T) {
+	userID := uuid.New()
+	session := newTestSession(CeremonySecondFactor, &userID)
+	conn := &sessionConn{sessions: map[string]*models.WebAuthnSession{session.ID.String(): session}}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewWebAuthnStore(db, &config.WebAuthnConfig{RPID: testRPID, Origins: []string{testOrigin}})
+
+	if _, err := s.consumeSession(context.Background(), session.ID, CeremonyLogin); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Fatalf("session of another ceremony: got %v, want ErrWebAuthnSessionInvalid", err)
+	}
+	consumed, err := s.consumeSession(context.Background(), session.ID, CeremonySecondFactor)
+	if err != nil {
+		t.Fatalf("live session rejected: %v", err)
+	}
+	if consumed.Challenge != session.Challenge || consumed.UserID == nil || *consumed.UserID != userID {
+		t.Fatalf("consumed session = %+v, want %+v", consumed, session)
+	}
+	if _, err := s.consumeSession(context.Background(), session.ID, CeremonySecondFactor); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Fatalf("consumed session reused: got %v, want ErrWebAuthnSessionInvalid", err)
+	}
+}
+
+func TestParseAuthenticatorDataRejectsMalformed(t *testing.T) {
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	valid := a.authenticatorData(true)
+	if _, err := parseAuthenticatorData(valid); err != nil {
+		t.Fatalf("valid authenticator data rejected: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"too short":           valid[:36],
+		"no credential data":  valid[:40],
+		"credential id":       valid[:37+18+8],
+		"truncated key":       valid[:len(valid)-1],
+		"trailing data":       append(append([]byte(nil), valid...), 0x00),
+		"missing extensions":  append(append([]byte(nil), valid[:32]...), append([]byte{valid[32] | authDataExtensions}, valid[33:]...)...),
+		"oversized id length": append(append([]byte(nil), valid[:37+16]...), 0x04, 0x00),
+	}
+	for name, raw := range tests {
+		if _, err := parseAuthenticatorData(raw); !errors.Is(err, ErrWebAuthnVerification) {
+			t.Errorf("%s: got %v, want ErrWebAuthnVerification", name, err)
+		}
+	}
+}
+
+func TestParseCOSEKeyRejectsInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"not cbor":           {0xff},
+		"not a map":          encodeCBOR(int64(1)),
+		"unsupported alg":    encodeCBOR(cborMap{{int64(1), int64(2)}, {int64(3), int64(-35)}}),
+		"short coordinates":  encodeCBOR(cborMap{{int64(1), int64(2)}, {int64(3), int64(COSEAlgES256)}, {int64(-1), int64(1)}, {int64(-2), make([]byte, 31)}, {int64(-3), make([]byte, 32)}}),
+		"point not on curve": encodeCBOR(cborMap{{int64(1), int64(2)}, {int64(3), int64(COSEAlgES256)}, {int64(-1), int64(1)}, {int64(-2), make([]byte, 32)}, {int64(-3), make([]byte, 32)}}),
+		"wrong curve":        encodeCBOR(cborMap{{int64(1), int64(1)}, {int64(3), int64(COSEAlgEdDSA)}, {int64(-1), int64(4)}, {int64(-2), make([]byte, 32)}}),
+		"short rsa modulus":  encodeCBOR(cborMap{{int64(1), int64(3)}, {int64(3), int64(COSEAlgRS256)}, {int64(-1), make([]byte, 128)}, {int64(-2), []byte{1, 0, 1}}}),
+	}
+	for name, raw := range tests {
+		if _, err := parseCOSEKey(raw); !errors.Is(err, ErrWebAuthnVerification) {
+			t.Errorf("%s: got %v, want ErrWebAuthnVerification", name, err)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	raw := append(encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{int64(-2), []byte{1, 2}},
+		{"list", []interface{}{int64(24), int64(-500), true}},
+	}), 0x01)
+
+	decoded, rest, err := decodeCBOR(raw)
+	if err != nil {
+		t.Fatalf("valid input rejected: %v", err)
+	}
+	if len(rest) != 1 || rest[0] != 0x01 {
+		t.Fatalf("remaining input = %x, want 01", rest)
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("decoded %T, want a map", decoded)
+	}
+	list, _ := m["list"].([]interface{})
+	if m["fmt"] != "none" || string(m[int64(-2)].([]byte)) != "\x01\x02" || len(list) != 3 || list[0] != int64(24) || list[1] != int64(-500) || list[2] != true {
+		t.Fatalf("decoded %v", m)
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	deep := make([]byte, maxCBORDepth+2)
+	for i := range deep {
+		deep[i] = 0x81 // array of one item
+	}
+
+	tests := map[string][]byte{
+		"empty":                      {},
+		"truncated argument":         {0x19, 0x01},
+		"reserved additional info":   {0x1c},
+		"indefinite length":          {0x5f, 0x41, 0x00, 0xff},
+		"byte string past the end":   {0x43, 0x01, 0x02},
+		"text string past the end":   {0x63, 'a'},
+		"array count past the end":   {0x85, 0x01},
+		"truncated array":            {0x82, 0x01},
+		"map count past the end":     {0xa3, 0x01, 0x02},
+		"truncated map value":        {0xa1, 0x01},
+		"byte string map key":        {0xa1, 0x41, 0x00, 0x01},
+		"unsigned past int64":        {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"negative past int64":        {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"tag":                        {0xc0, 0x01},
+		"half precision float":       {0xf9, 0x3c, 0x00},
+		"truncated float":            {0xfa, 0x00, 0x00},
+		"unassigned simple value":    {0xf8, 0x20},
+		"break outside indefinite":   {0xff},
+		"nesting past the max depth": deep,
+	}
+	for name, raw := range tests {
+		if _, _, err := decodeCBOR(raw); !errors.Is(err, ErrCBORMalformed) {
+			t.Errorf("%s: got %v, want ErrCBORMalformed", name, err)
+		}
+	}
+}
+
+// cborMap is a map encoded with its keys in the given order
+type cborMap []cborPair
+
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeCBOR encodes the subset of CBOR the tests need
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair.key)...)
+			out = append(out, encodeCBOR(pair.value)...)
+		}
+		return out
+	default:
+		panic("unsupported cbor value")
+	}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
+
+// sessionConn is a database connection that only answers the query of
+// consumeSession, deleting sessions from memory the way the table would
+type sessionConn struct {
+	mu       sync.Mutex
+	sessions map[string]*models.WebAuthnSession
+}
+
+func (c *sessionConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *sessionConn) Driver() driver.Driver                        { return nil }
+func (c *sessionConn) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c *sessionConn) Close() error                                 { return nil }
+func (c *sessionConn) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *sessionConn) Commit() error                                { return nil }
+func (c *sessionConn) Rollback() error                              { return nil }
+
+// QueryContext takes the id and ceremony arguments of the DELETE ... RETURNING
+func (c *sessionConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) < 2 {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	id, _ := args[0].Value.(string)
+	ceremony, _ := args[1].Value.(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rows := &sessionRows{}
+	if session, ok := c.sessions[id]; ok && session.Ceremony == ceremony {
+		delete(c.sessions, id)
+		rows.values = [][]driver.Value{{
+			session.ID.String(), session.UserID.String(), session.Ceremony, session.Challenge, session.ExpiresAt, session.CreatedAt,
+		}}
+	}
+	return rows, nil
+}
+
+type sessionRows struct {
+	values [][]driver.Value
+}
+
+func (r *sessionRows) Columns() []string {
+	return []string{"id", "user_id", "ceremony", "challenge", "expires_at", "created_at"}
+}
+
+func (r *sessionRows) Close() error { return nil }
+
+func (r *sessionRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/HersheyPlus/go-auth/config"
+	"github.com/HersheyPlus/go-auth/models"
+)
+
+// NewWebhookHandler posts event entries as JSON to the configured webhook.
+// The entry ID is sent in X-Webhook-ID so receivers can drop redeliveries.
+// Without a webhook URL events are discarded.
+func NewWebhookHandler(cfg *config.WebhookConfig) OutboxHandler {
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	return func(ctx context.Context, msg *models.OutboxMessage) error {
+		if cfg.URL == "" {
+			return nil
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, strings.NewReader(msg.Payload))
+		if err != nil {
+			return fmt.Errorf("failed to create webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-ID", msg.ID.String())
+		if cfg.Secret != "" {
+			mac := hmac.New(sha256.New, []byte(cfg.Secret))
+			mac.Write([]byte(msg.Payload))
+			req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook responded with %s", resp.Status)
+		}
+		return nil
+	}
+}